@@ -0,0 +1,86 @@
+package geostore
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// CacheStats reports the activity of the decoded entry cache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Len    int // Number of entries currently cached
+}
+
+// entryCache is a bounded LRU cache of decoded entries keyed by ID, so hot objects
+// skip decodeFullEntry and keep their decoded shapes between queries.
+type entryCache struct {
+	lru          *lru.Cache[string, cachedEntry]
+	hits, misses atomic.Uint64
+}
+
+// cachedEntry is a decoded entry with the stamp of the blob it was decoded from.
+type cachedEntry struct {
+	length int
+	stamp  uint64
+	entry  *decodedEntry
+}
+
+func newEntryCache(size int) (*entryCache, error) {
+	l, err := lru.New[string, cachedEntry](size)
+	if err != nil {
+		return nil, err
+	}
+	return &entryCache{lru: l}, nil
+}
+
+// decode returns the decoded entry for the blob data stored under id, from the cache
+// when it holds the same blob. Blobs are identified by their length and the stamp
+// written in their header, so a hit doesn't read the blob and a query never sees
+// an entry older or newer than its transaction. Legacy blobs have no stamp and are
+// decoded without caching, Migrate upgrades them.
+func (c *entryCache) decode(id string, data []byte) (*decodedEntry, error) {
+	stamp, ok := blobStamp(data)
+	if !ok {
+		c.misses.Add(1)
+		return decodeFullEntry(data)
+	}
+	if ce, hit := c.lru.Get(id); hit && ce.length == len(data) && ce.stamp == stamp {
+		c.hits.Add(1)
+		return ce.entry, nil
+	}
+	c.misses.Add(1)
+
+	// The blob is copied, data is only valid during its transaction
+	entry, err := decodeFullEntry(bytes.Clone(data))
+	if err != nil {
+		return nil, err
+	}
+	c.lru.Add(id, cachedEntry{length: len(data), stamp: stamp, entry: entry})
+	return entry, nil
+}
+
+// remove drops the cached entries overwritten by entries.
+func (c *entryCache) remove(entries []IndexEntry) {
+	for _, e := range entries {
+		c.lru.Remove(e.ID)
+	}
+}
+
+// decodeEntry decodes the blob data stored under id, through the cache if enabled.
+func (gs *GeoStore) decodeEntry(id string, data []byte) (*decodedEntry, error) {
+	if gs.cache == nil {
+		return decodeFullEntry(data)
+	}
+	return gs.cache.decode(id, data)
+}
+
+// CacheStats returns the statistics of the decoded entry cache, zero when disabled.
+func (gs *GeoStore) CacheStats() CacheStats {
+	if gs.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{Hits: gs.cache.hits.Load(), Misses: gs.cache.misses.Load(), Len: gs.cache.lru.Len()}
+}
@@ -0,0 +1,147 @@
+package geostore
+
+import (
+	"fmt"
+	"testing"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// TestEntryCache validates cached entries are reused and never served once overwritten
+func TestEntryCache(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CacheSize = 2
+	store := newTestStore(t, opts)
+
+	write := func(id string, lng float64, name string) {
+		t.Helper()
+		feature := polygonFeature([][]float64{
+			{lng, 43.6}, {lng + 0.1, 43.6}, {lng + 0.1, 43.7}, {lng, 43.7}, {lng, 43.6},
+		})
+		feature.Properties = map[string]any{"name": name}
+		entry, err := store.PrepareIndexEntry(id, feature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := func(lng float64) []StoredItem {
+		t.Helper()
+		results, err := store.FindClosest(43.65, lng, 10, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	write("a", -79.4, "first")
+	for range 3 {
+		if results := query(-79.35); len(results) != 1 || results[0].Properties["name"] != "first" {
+			t.Fatalf("unexpected results %+v", results)
+		}
+	}
+	if stats := store.CacheStats(); stats.Hits != 2 || stats.Misses != 1 || stats.Len != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Overwritten with another geometry, the cached entry must not answer anymore
+	write("a", -78.4, "second")
+	if results := query(-79.35); len(results) != 0 {
+		t.Errorf("stale entry served: %+v", results)
+	}
+	if results := query(-78.35); len(results) != 1 || results[0].Properties["name"] != "second" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	item, err := store.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Properties["name"] != "second" {
+		t.Errorf("stale entry from Get: %+v", item)
+	}
+
+	// Rewritten with a blob of the same length, the stamp tells them apart
+	write("a", -78.4, "third1")
+	if results := query(-78.35); len(results) != 1 || results[0].Properties["name"] != "third1" {
+		t.Errorf("stale entry served for a blob of the same length: %+v", results)
+	}
+
+	// Bounded
+	write("b", -77.4, "b")
+	write("c", -76.4, "c")
+	query(-77.35)
+	query(-76.35)
+	if stats := store.CacheStats(); stats.Len != 2 {
+		t.Errorf("expected the cache to be bounded to 2 entries, got %d", stats.Len)
+	}
+}
+
+// TestBlobStamp validates the stamp is read from the header of current blobs only
+func TestBlobStamp(t *testing.T) {
+	feature := polygonFeature([][]float64{{-79.4, 43.6}, {-79.3, 43.6}, {-79.3, 43.7}, {-79.4, 43.7}, {-79.4, 43.6}})
+	stamps := make(map[uint64]bool)
+	for _, c := range []Compression{CompressionNone, CompressionZstd} {
+		opts := DefaultOptions()
+		opts.Compression = c
+		store := newTestStore(t, opts)
+		for _, name := range []string{"a", "b"} {
+			feature.Properties = map[string]any{"name": name}
+			entry, err := store.PrepareIndexEntry(name, feature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stamp, ok := blobStamp(entry.Blob)
+			if !ok {
+				t.Fatalf("no stamp in a version %d blob", entry.Blob[len(blobMagic)])
+			}
+			stamps[stamp] = true
+			if _, err := decodeFullEntry(entry.Blob); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(stamps) != 4 {
+		t.Errorf("expected 4 distinct stamps, got %d", len(stamps))
+	}
+
+	shapes, _, err := geomToS2(feature.Geometry, &validator{policy: ValidationFix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := blobStamp(encodeLegacyEntry(t, shapes, []byte(`{"name":"a"}`))); ok {
+		t.Error("expected no stamp in a legacy blob")
+	}
+	unknown := append(append([]byte{}, blobMagic...), blobVersionCurrent+1, byte(CompressionNone), byte(PropertiesJSON))
+	if _, ok := blobStamp(append(unknown, make([]byte, 16)...)); ok {
+		t.Error("expected no stamp in a blob of an unknown version")
+	}
+}
+
+// BenchmarkEntryCache reports the latency of repeated point in polygon lookups on a large polygon
+func BenchmarkEntryCache(b *testing.B) {
+	country := geom.NewPolygon([]geom.LineString{circleRing(-79.38, 43.65, 2, 20000, false)}).AsGeometry()
+	for _, size := range []int{0, 16} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.CacheSize = size
+			store := newTestStore(b, opts)
+			entry, err := store.PrepareIndexEntry("country", geom.GeoJSONFeature{Geometry: country})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				results, err := store.FindClosest(43.65, -79.38, 10, false)
+				if err != nil || len(results) != 1 {
+					b.Fatalf("unexpected results %v, %v", results, err)
+				}
+			}
+		})
+	}
+}
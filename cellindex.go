@@ -0,0 +1,352 @@
+package geostore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/golang/geo/s2"
+)
+
+// s2IndexModule is the S2 module cellIndex was checked against, see TestCellIndexRoundTrip.
+const s2IndexModule = "github.com/akhenakh/geo@v0.0.0-20260101161651-4227fdd81f2e"
+
+// cellIndex reads in place the S2ShapeIndex encoded at the end of a blob:
+// [MaxEdgesVersion][CellIDs][Cells]. Unlike s2.EncodedShapeIndex it exposes the
+// edges clipped to each cell, so refinement only measures the edges of the cells
+// near the query instead of every edge of the shapes, and it doesn't copy the
+// encoded index. Cells are decoded on demand and not retained.
+//
+// The S2 queries can't be used instead: in s2IndexModule ClosestEdgeQuery and
+// ContainsPointQuery only run over an in-memory s2.ShapeIndex, which indexes every
+// edge again, and s2.EncodedShapeIndex keeps the clipped edges private and panics
+// on a corrupted cell. The encoding read here is the S2ShapeIndex encoding shared
+// with the C++ and Java libraries, not an internal of the Go one.
+type cellIndex struct {
+	numShapes int
+	ids       cellIDVector
+	offsets   uintVector // End offset of each encoded cell in cells
+	cells     []byte
+}
+
+// clippedShape is a shape clipped to a cell: the edges of the shape intersecting
+// the cell, in increasing order, and whether the shape contains the cell center.
+type clippedShape struct {
+	shape          int32
+	containsCenter bool
+	edges          []int
+}
+
+// decodeCellIndex parses the index encoded in data for numShapes shapes.
+func decodeCellIndex(data []byte, numShapes int) (*cellIndex, error) {
+	r := &sliceReader{data: data}
+	if version := r.uvarint() & 3; r.err == nil && version != 0 {
+		return nil, fmt.Errorf("unsupported shape index version %d", version)
+	}
+	x := &cellIndex{numShapes: numShapes}
+	x.ids = r.cellIDVector()
+	x.offsets = r.uintVector()
+	if r.err == nil && x.offsets.size != x.ids.deltas.size {
+		return nil, fmt.Errorf("shape index with %d cell IDs and %d cells", x.ids.deltas.size, x.offsets.size)
+	}
+	if r.err == nil && x.offsets.size > 0 {
+		x.cells = r.bytes(x.offsets.get(x.offsets.size - 1))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("decoding shape index: %w", r.err)
+	}
+	return x, nil
+}
+
+// len returns the number of cells.
+func (x *cellIndex) len() int { return x.offsets.size }
+
+// cellID returns the ID of the i-th cell, cells are ordered by ID.
+func (x *cellIndex) cellID(i int) s2.CellID { return x.ids.get(i) }
+
+// seek returns the position of the first cell with an ID >= id, len when there is none.
+func (x *cellIndex) seek(id s2.CellID) int {
+	return sort.Search(x.len(), func(i int) bool { return x.cellID(i) >= id })
+}
+
+// locate returns the position of the cell containing the leaf cell of p, if any.
+func (x *cellIndex) locate(p s2.Point) (int, bool) {
+	leaf := s2.CellFromPoint(p).ID()
+	i := x.seek(leaf)
+	if i < x.len() && x.cellID(i).RangeMin() <= leaf {
+		return i, true
+	}
+	if i > 0 && x.cellID(i-1).RangeMax() >= leaf {
+		return i - 1, true
+	}
+	return 0, false
+}
+
+// intersecting calls fn with the positions of the cells intersecting the cell ID
+// ranges of the covering, each position once, in increasing order, until fn returns false.
+func (x *cellIndex) intersecting(covering s2.CellUnion, fn func(i int) bool) {
+	next := 0 // Positions below were visited, the covering is sorted
+	for _, c := range covering {
+		// A cell containing c starts before it
+		i := max(x.seek(c.RangeMin()), next)
+		if i > next && x.cellID(i-1).RangeMax() >= c.RangeMin() {
+			i--
+		}
+		for ; i < x.len() && x.cellID(i).RangeMin() <= c.RangeMax(); i++ {
+			if !fn(i) {
+				return
+			}
+		}
+		next = i
+	}
+}
+
+// cell decodes the shapes clipped to the i-th cell, following S2ShapeIndexCell::Decode.
+func (x *cellIndex) cell(i int) ([]clippedShape, error) {
+	start := uint64(0)
+	if i > 0 {
+		start = x.offsets.get(i - 1)
+	}
+	end := x.offsets.get(i)
+	if start > end || end > uint64(len(x.cells)) {
+		return nil, fmt.Errorf("cell %d out of the shape index", i)
+	}
+	r := &sliceReader{data: x.cells[start:end]}
+
+	if x.numShapes == 1 {
+		// Single shape indexes have a shorter encoding
+		c := clippedShape{}
+		header := r.uvarint()
+		switch {
+		case header&1 == 0: // Edge range
+			c.containsCenter = header&2 != 0
+			c.edges = edgeRange(int(header>>6), int(header>>2&15)+2)
+		case header&2 == 0: // Single edge
+			c.containsCenter = header&4 != 0
+			c.edges = []int{int(header >> 3)}
+		default:
+			c.containsCenter = header&4 != 0
+			c.edges = r.edges(int(header >> 3))
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("decoding cell %d: %w", i, r.err)
+		}
+		return []clippedShape{c}, nil
+	}
+
+	header := r.uvarint()
+	numClipped := 1
+	if header&7 == 3 {
+		numClipped = int(header >> 3)
+		header = r.uvarint()
+	}
+	if numClipped > x.numShapes {
+		return nil, fmt.Errorf("cell %d with %d shapes out of %d", i, numClipped, x.numShapes)
+	}
+	shapes := make([]clippedShape, numClipped)
+	shape := uint64(0)
+	for j := range shapes {
+		if j > 0 {
+			header = r.uvarint()
+		}
+		c := &shapes[j]
+		var delta uint64 // From the previous shape
+		switch {
+		case header&1 == 0: // Edge range
+			countDelta := r.uvarint()
+			delta = countDelta >> 4
+			c.containsCenter = header&2 != 0
+			c.edges = edgeRange(int(header>>2), int(countDelta&15)+1)
+		case header&7 == 7: // No edges
+			delta = header >> 4
+			c.containsCenter = header&8 != 0
+		case header&3 == 1:
+			delta = r.uvarint()
+			c.containsCenter = header&4 != 0
+			c.edges = r.edges(int(header>>3) + 1)
+		default:
+			return nil, fmt.Errorf("cell %d: invalid clipped shape header %d", i, header)
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("decoding cell %d: %w", i, r.err)
+		}
+		if delta >= uint64(x.numShapes) || shape+delta >= uint64(x.numShapes) {
+			return nil, fmt.Errorf("cell %d: shape %d + %d out of %d", i, shape, delta, x.numShapes)
+		}
+		shape += delta
+		c.shape = int32(shape)
+		shape++
+	}
+	return shapes, nil
+}
+
+func edgeRange(start, n int) []int {
+	edges := make([]int, n)
+	for i := range edges {
+		edges[i] = start + i
+	}
+	return edges
+}
+
+// uintVector is an S2 EncodedUintVector of uint64: values of a fixed width in bytes, little endian.
+type uintVector struct {
+	data  []byte
+	size  int
+	width int
+}
+
+func (v uintVector) get(i int) uint64 {
+	var val uint64
+	for b, c := range v.data[i*v.width : (i+1)*v.width] {
+		val |= uint64(c) << (8 * b)
+	}
+	return val
+}
+
+// cellIDVector is an S2 EncodedS2CellIdVector: deltas from a base, shifted.
+type cellIDVector struct {
+	deltas uintVector
+	base   uint64
+	shift  uint8
+}
+
+func (v cellIDVector) get(i int) s2.CellID {
+	return s2.CellID(v.deltas.get(i)<<v.shift + v.base)
+}
+
+var errTruncated = errors.New("truncated")
+
+// sliceReader decodes the S2 encodings from a byte slice, the first error sticks.
+type sliceReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sliceReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *sliceReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *sliceReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *sliceReader) uintVector() uintVector {
+	sizeLen := r.uvarint()
+	v := uintVector{size: int(sizeLen / 8), width: int(sizeLen&7) + 1}
+	if r.err == nil && sizeLen/8 > uint64(len(r.data)) {
+		r.err = errTruncated
+		return v
+	}
+	v.data = r.bytes(uint64(v.size * v.width))
+	return v
+}
+
+func (r *sliceReader) cellIDVector() cellIDVector {
+	var v cellIDVector
+	code := r.byte()
+	shiftCode := code >> 3
+	if shiftCode == 31 {
+		shiftCode = 29 + r.byte()
+		if r.err == nil && shiftCode > 56 {
+			r.err = fmt.Errorf("invalid cell ID shift code %d", shiftCode)
+		}
+	}
+	baseLen := int(code & 7)
+	for i, b := range r.bytes(uint64(baseLen)) {
+		v.base |= uint64(b) << (8 * i)
+	}
+	v.base <<= 64 - 8*max(1, baseLen)
+	if shiftCode >= 29 {
+		v.shift = 2*(shiftCode-29) + 1
+		v.base |= 1 << (v.shift - 1)
+	} else {
+		v.shift = 2 * shiftCode
+	}
+	v.deltas = r.uintVector()
+	return v
+}
+
+// edgeDelta adds delta to the edge ID, failing when it overflows: edge IDs are
+// increasing and fit in an int32.
+func (r *sliceReader) edgeDelta(edge *int, delta uint64) bool {
+	if r.err != nil {
+		return false
+	}
+	if delta > math.MaxInt32 || *edge+int(delta) > math.MaxInt32 {
+		r.err = fmt.Errorf("edge %d + %d out of range", *edge, delta)
+		return false
+	}
+	*edge += int(delta)
+	return true
+}
+
+// edges decodes n delta encoded edge IDs, following S2ShapeIndexCell::DecodeEdges.
+func (r *sliceReader) edges(n int) []int {
+	if r.err != nil {
+		return nil
+	}
+	// Runs encode many edges in a few bytes, n isn't bounded by the data
+	edges := make([]int, 0, min(n, 1024))
+	edge := 0
+	for len(edges) < n && r.err == nil {
+		delta := r.uvarint()
+		if len(edges)+1 == n {
+			if !r.edgeDelta(&edge, delta) {
+				return nil
+			}
+			edges = append(edges, edge)
+			break
+		}
+		count := int(delta&7) + 1
+		delta >>= 3
+		if count == 8 {
+			if delta > uint64(n) {
+				r.err = fmt.Errorf("run of %d edges in a cell of %d", delta, n)
+				return nil
+			}
+			count = int(delta) + 8
+			delta = r.uvarint()
+		}
+		if !r.edgeDelta(&edge, delta) {
+			return nil
+		}
+		if len(edges)+count > n {
+			r.err = fmt.Errorf("%d edges in a cell of %d", len(edges)+count, n)
+			return nil
+		}
+		for k := range count {
+			edges = append(edges, edge+k)
+		}
+		edge += count
+	}
+	return edges
+}
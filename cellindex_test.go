@@ -0,0 +1,216 @@
+package geostore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// TestCellIndexPinned validates the S2 module is the one cellIndex was checked against,
+// bumping it requires the round trip tests below to pass.
+func TestCellIndexPinned(t *testing.T) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		t.Skip("no build info")
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/golang/geo" {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if got := dep.Path + "@" + dep.Version; got != s2IndexModule {
+			t.Errorf("cellIndex reads the encoding of %s, built with %s", s2IndexModule, got)
+		}
+		return
+	}
+	t.Error("github.com/golang/geo not found in the build info")
+}
+
+// testShapeIndexes returns S2 indexes with a single shape and several shapes of every
+// dimension, encoded single shapes and multiple shapes clipped cells differently.
+func testShapeIndexes() map[string][]s2.Shape {
+	ring := func(lat, lng, radius float64, n int) *s2.Loop {
+		return s2.RegularLoop(s2.PointFromLatLng(s2.LatLngFromDegrees(lat, lng)), s1.Angle(radius)*s1.Degree, n)
+	}
+	line := s2.PolylineFromLatLngs([]s2.LatLng{
+		s2.LatLngFromDegrees(43.6, -79.5), s2.LatLngFromDegrees(43.7, -79.3), s2.LatLngFromDegrees(43.6, -79.1),
+	})
+	points := s2.PointVector{}
+	for i := range 100 {
+		points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(43.6+0.001*float64(i), -79.4)))
+	}
+	return map[string][]s2.Shape{
+		"small polygon": {s2.PolygonFromLoops([]*s2.Loop{ring(43.65, -79.38, 0.1, 8)})},
+		"large polygon": {s2.PolygonFromLoops([]*s2.Loop{ring(43.65, -79.38, 2, 5000)})},
+		"holes":         {s2.PolygonFromOrientedLoops([]*s2.Loop{ring(43.65, -79.38, 1, 500), invertedLoop(ring(43.65, -79.38, 0.5, 300))})},
+		"line":          {line},
+		"points":        {&points},
+		"mixed": {
+			s2.PolygonFromLoops([]*s2.Loop{ring(43.65, -79.38, 1, 1000)}),
+			line, &points,
+			s2.PolygonFromLoops([]*s2.Loop{ring(43.65, -78.38, 0.5, 200)}),
+		},
+	}
+}
+
+func invertedLoop(l *s2.Loop) *s2.Loop {
+	l.Invert()
+	return l
+}
+
+func encodeShapeIndex(t *testing.T, shapes []s2.Shape) []byte {
+	t.Helper()
+	index := s2.NewShapeIndex()
+	for _, shape := range shapes {
+		index.Add(shape)
+	}
+	index.Build()
+	var buf bytes.Buffer
+	if err := index.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestCellIndexRoundTrip validates cellIndex reads the indexes encoded by S2 like
+// s2.EncodedShapeIndex does, and that the clipped edges are the edges of the cells
+func TestCellIndexRoundTrip(t *testing.T) {
+	for name, shapes := range testShapeIndexes() {
+		data := encodeShapeIndex(t, shapes)
+		x, err := decodeCellIndex(data, len(shapes))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		encoded := s2.NewEncodedShapeIndex()
+		if err := encoded.Init(bytes.NewReader(data), &s2.BasicShapeFactory{Shapes: shapes}); err != nil {
+			t.Fatal(err)
+		}
+		it := encoded.Iterator()
+		seen := make([]map[int]bool, len(shapes))
+		for i := range seen {
+			seen[i] = make(map[int]bool)
+		}
+		i := 0
+		for ; !it.Done(); it.Next() {
+			if i >= x.len() {
+				t.Fatalf("%s: %d cells, more in the S2 index", name, x.len())
+			}
+			if x.cellID(i) != it.CellID() {
+				t.Fatalf("%s: cell %d is %v, want %v", name, i, x.cellID(i), it.CellID())
+			}
+			clipped, err := x.cell(i)
+			if err != nil {
+				t.Fatalf("%s: cell %d: %v", name, i, err)
+			}
+			want := it.IndexCell()
+			if len(clipped) != want.NumClipped() {
+				t.Fatalf("%s: cell %d with %d shapes, want %d", name, i, len(clipped), want.NumClipped())
+			}
+			cell := s2.CellFromCellID(x.cellID(i))
+			for j, c := range clipped {
+				if c.shape != want.ClippedID(j) || c.containsCenter != want.ClippedContainsCenter(j) {
+					t.Errorf("%s: cell %d shape %d: got %d/%v, want %d/%v", name, i, j,
+						c.shape, c.containsCenter, want.ClippedID(j), want.ClippedContainsCenter(j))
+				}
+				shape := shapes[c.shape]
+				for _, e := range c.edges {
+					edge := shape.Edge(e)
+					// Edges are clipped to the cell with a small padding
+					if d := cell.DistanceToEdge(edge.V0, edge.V1); d.Angle() > 1e-9 {
+						t.Errorf("%s: edge %d of shape %d is %v away from cell %d", name, e, c.shape, d.Angle(), i)
+					}
+					seen[c.shape][e] = true
+				}
+			}
+			i++
+		}
+		if i != x.len() {
+			t.Errorf("%s: %d cells, want %d", name, x.len(), i)
+		}
+		for s, shape := range shapes {
+			if len(seen[s]) != shape.NumEdges() {
+				t.Errorf("%s: %d edges of shape %d in the cells, want %d", name, len(seen[s]), s, shape.NumEdges())
+			}
+		}
+	}
+}
+
+// readCellIndex decodes the index and every cell, returning the first error.
+func readCellIndex(data []byte, numShapes int) error {
+	x, err := decodeCellIndex(data, numShapes)
+	if err != nil {
+		return err
+	}
+	for i := range x.len() {
+		clipped, err := x.cell(i)
+		if err != nil {
+			return err
+		}
+		// Callers only check the edges against the number of edges of the shape
+		for _, c := range clipped {
+			if c.shape < 0 || int(c.shape) >= numShapes {
+				return fmt.Errorf("decoded shape %d out of %d", c.shape, numShapes)
+			}
+			for k, e := range c.edges {
+				if e < 0 || (k > 0 && e <= c.edges[k-1]) {
+					return fmt.Errorf("decoded edges %v", c.edges)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TestCellIndexCorrupted validates truncated and corrupted indexes fail to decode instead of panicking
+func TestCellIndexCorrupted(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for name, shapes := range testShapeIndexes() {
+		data := encodeShapeIndex(t, shapes)
+		for n := range len(data) {
+			if err := readCellIndex(data[:n], len(shapes)); err == nil {
+				t.Errorf("%s: read %d bytes out of %d", name, n, len(data))
+			}
+		}
+
+		for range 2000 {
+			corrupted := bytes.Clone(data)
+			for range 1 + rng.IntN(4) {
+				corrupted[rng.IntN(len(corrupted))] = byte(rng.Uint32())
+			}
+			// Any decoding error, no panic nor invalid cell
+			if err := readCellIndex(corrupted, len(shapes)); err != nil && strings.HasPrefix(err.Error(), "decoded") {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+// TestCellIndexEdgeOverflow validates edge IDs overflowing in a corrupted cell are refused
+func TestCellIndexEdgeOverflow(t *testing.T) {
+	for _, deltas := range [][]uint64{
+		{1 << 62, 1 << 63},       // Last delta wrapping the edge ID
+		{(1 << 40) << 3, 1},      // First delta out of the int32 range
+		{7 | (1<<60)<<3, 0, 1},   // Run longer than the cell
+		{0, math.MaxUint64 >> 1}, // Last delta out of the int32 range
+	} {
+		var data []byte
+		for _, d := range deltas {
+			data = binary.AppendUvarint(data, d)
+		}
+		r := &sliceReader{data: data}
+		if edges := r.edges(len(deltas)); r.err == nil {
+			t.Errorf("deltas %v decoded as %v", deltas, edges)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	geostore "github.com/akhenakh/geobbolt"
+)
+
+// progress tracks the low watermark of handled features.
+// Workers finish features out of order, the checkpoint may only cover
+// a feature once every feature before it is committed or rejected.
+// It is not safe for concurrent use, it is owned by the writer.
+type progress struct {
+	next    int64           // First ordinal not yet covered by the watermark
+	offset  int64           // Input offset right after feature next-1
+	pending map[int64]int64 // Handled ordinals beyond the watermark, with their end offset
+}
+
+// newProgress resumes from cp, its handled features are pending beyond the watermark.
+func newProgress(cp geostore.Checkpoint) *progress {
+	p := &progress{next: cp.Ordinal, offset: cp.Offset, pending: make(map[int64]int64)}
+	maps.Copy(p.pending, cp.Handled)
+	return p
+}
+
+// done marks a feature as handled and advances the watermark if possible.
+func (p *progress) done(ordinal, offset int64) {
+	p.pending[ordinal] = offset
+	for {
+		off, ok := p.pending[p.next]
+		if !ok {
+			return
+		}
+		delete(p.pending, p.next)
+		p.offset = off
+		p.next++
+	}
+}
+
+// checkpoint returns the watermark with the features handled beyond it, a resumed
+// import skips them so they aren't handled twice.
+func (p *progress) checkpoint(fingerprint string) geostore.Checkpoint {
+	cp := geostore.Checkpoint{Offset: p.offset, Ordinal: p.next, Fingerprint: fingerprint}
+	if len(p.pending) > 0 {
+		cp.Handled = maps.Clone(p.pending)
+	}
+	return cp
+}
+
+// sequencer releases the results of the workers in import order. Workers finish
+// features out of order, the duplicate policy must see them in input order so the
+// feature kept or suffixed doesn't depend on the scheduling. Only the results of
+// the features in flight are buffered.
+// It is not safe for concurrent use, it is owned by the writer.
+type sequencer struct {
+	next    int64            // Ordinal of the next result to release
+	handled map[int64]int64  // Features handled before resuming, they are never fed
+	waiting map[int64]Result // Results finished before the ones preceding them
+}
+
+// newSequencer starts at the checkpoint watermark, skipping the features it handled.
+func newSequencer(cp geostore.Checkpoint) *sequencer {
+	return &sequencer{next: cp.Ordinal, handled: cp.Handled, waiting: make(map[int64]Result)}
+}
+
+// add buffers res and passes the results now in order to release.
+func (s *sequencer) add(res Result, release func(Result)) {
+	s.waiting[res.Ordinal] = res
+	for {
+		if _, ok := s.handled[s.next]; ok {
+			s.next++
+			continue
+		}
+		res, ok := s.waiting[s.next]
+		if !ok {
+			return
+		}
+		delete(s.waiting, s.next)
+		s.next++
+		release(res)
+	}
+}
+
+// headSize is the length of the start of the input hashed into its fingerprint.
+const headSize = 1 << 20
+
+// inputIdentity returns the checkpoint name and fingerprint for an input file:
+// its size and a hash of its start, f is read without moving its offset.
+func inputIdentity(f *os.File) (name, fingerprint string, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return "", "", err
+	}
+	abs, err := filepath.Abs(f.Name())
+	if err != nil {
+		return "", "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, headSize)); err != nil {
+		return "", "", err
+	}
+	return "index:" + abs, fmt.Sprintf("size:%d head:%x", fi.Size(), h.Sum(nil)[:16]), nil
+}
+
+// featureDecoder returns a decoder positioned inside the features array, and
+// the absolute offset of the decoder's input start (InputOffset is relative to it).
+// With a checkpoint the input is seeked past the last handled feature, the
+// remaining features are presented to the decoder as a new array.
+func featureDecoder(f *os.File, cp geostore.Checkpoint) (*json.Decoder, int64, error) {
+	if cp.Offset == 0 {
+		dec := json.NewDecoder(f)
+
+		// Locate the "features" array in the stream
+		// This logic assumes standard FeatureCollection structure
+		// { "type": "FeatureCollection", "features": [ ... ] }
+		for {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, 0, err
+			}
+			if s, ok := t.(string); ok && s == "features" {
+				break
+			}
+		}
+
+		// Read opening bracket of array
+		if _, err := dec.Token(); err != nil {
+			return nil, 0, err
+		}
+		return dec, 0, nil
+	}
+
+	if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	br := bufio.NewReader(f)
+	skipped := int64(0)
+
+	// Consume the separator following the last handled feature
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading after checkpoint: %w", err)
+		}
+		skipped++
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		if c == ']' {
+			// All features were handled, present an empty array
+			return featureArray(strings.NewReader("]"), cp.Offset+skipped-1)
+		}
+		if c != ',' {
+			return nil, 0, fmt.Errorf("unexpected %q after checkpoint offset %d", c, cp.Offset)
+		}
+		break
+	}
+
+	return featureArray(br, cp.Offset+skipped)
+}
+
+func featureArray(r io.Reader, base int64) (*json.Decoder, int64, error) {
+	dec := json.NewDecoder(io.MultiReader(strings.NewReader("["), r))
+	if _, err := dec.Token(); err != nil {
+		return nil, 0, err
+	}
+	// The opening bracket is not part of the input
+	return dec, base - 1, nil
+}
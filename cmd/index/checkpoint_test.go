@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+)
+
+// TestProgress validates the watermark only covers features once every feature before them is handled
+func TestProgress(t *testing.T) {
+	p := newProgress(geostore.Checkpoint{})
+	p.done(1, 20)
+	p.done(2, 30)
+	if cp := p.checkpoint("f"); cp.Ordinal != 0 || cp.Offset != 0 ||
+		!reflect.DeepEqual(cp.Handled, map[int64]int64{1: 20, 2: 30}) {
+		t.Errorf("feature 0 pending, got %+v", cp)
+	}
+	p.done(0, 10)
+	if cp := p.checkpoint("f"); cp.Ordinal != 3 || cp.Offset != 30 || cp.Handled != nil || cp.Fingerprint != "f" {
+		t.Errorf("expected the watermark after feature 2, got %+v", cp)
+	}
+
+	// Resumed, the features handled beyond the watermark stay pending
+	p = newProgress(geostore.Checkpoint{Ordinal: 3, Offset: 30, Handled: map[int64]int64{5: 50}})
+	p.done(3, 35)
+	if cp := p.checkpoint("f"); cp.Ordinal != 4 || cp.Offset != 35 || len(cp.Handled) != 1 {
+		t.Errorf("expected the watermark after feature 3, got %+v", cp)
+	}
+	p.done(4, 40)
+	if cp := p.checkpoint("f"); cp.Ordinal != 6 || cp.Offset != 50 || cp.Handled != nil {
+		t.Errorf("expected the watermark after feature 5, got %+v", cp)
+	}
+}
+
+// TestSequencer validates results are released in import order whatever the order
+// the workers finish them, so the duplicate policy is deterministic
+func TestSequencer(t *testing.T) {
+	ids := []string{"a", "b", "a", "c", "a", "b", "d", "a"}
+	// Resumed after feature 0, feature 3 was handled beyond the watermark
+	cp := geostore.Checkpoint{Ordinal: 1, Handled: map[int64]int64{3: 30}}
+	want := map[dupPolicy][]string{
+		dupSkip:   {"b", "a", "d"},
+		dupSuffix: {"b", "a", "a-2", "b-2", "d", "a-3"},
+	}
+
+	for policy, want := range want {
+		for range 20 {
+			jobs := make(chan Job)
+			results := make(chan Result)
+			var wg sync.WaitGroup
+			for range 4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for job := range jobs {
+						time.Sleep(time.Duration(rand.IntN(200)) * time.Microsecond)
+						results <- Result{Job: job, Entry: geostore.IndexEntry{ID: ids[job.Ordinal]}}
+					}
+				}()
+			}
+			go func() {
+				for ordinal := range int64(len(ids)) {
+					if _, ok := cp.Handled[ordinal]; ordinal >= cp.Ordinal && !ok {
+						jobs <- Job{Ordinal: ordinal}
+					}
+				}
+				close(jobs)
+				wg.Wait()
+				close(results)
+			}()
+
+			// Batches of 2 features, committed to db
+			db := map[string]bool{}
+			exists := func(id string) (bool, error) { return db[id], nil }
+			seq := newSequencer(cp)
+			dups := newDedup(policy)
+			var got []string
+			var batch []string
+			for res := range results {
+				seq.add(res, func(res Result) {
+					id, ok, err := dups.resolve(res.Entry.ID, exists)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if ok {
+						batch = append(batch, id)
+					}
+					if len(batch) == 2 {
+						for _, id := range batch {
+							db[id] = true
+						}
+						got = append(got, batch...)
+						batch = batch[:0]
+						dups.reset()
+					}
+				})
+			}
+			got = append(got, batch...)
+			if !slices.Equal(got, want) {
+				t.Fatalf("%s: got %v, want %v", policy, got, want)
+			}
+			if len(seq.waiting) != 0 {
+				t.Fatalf("%s: %d results never released", policy, len(seq.waiting))
+			}
+		}
+	}
+}
+
+func writeInput(t *testing.T, data string) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.geojson")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// decodeFeatures returns the features of the decoder with their absolute end offsets.
+func decodeFeatures(t *testing.T, dec *json.Decoder, base int64) (features []string, offsets []int64) {
+	t.Helper()
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			t.Fatal(err)
+		}
+		features = append(features, string(raw))
+		offsets = append(offsets, base+dec.InputOffset())
+	}
+	return features, offsets
+}
+
+// TestFeatureDecoder validates resuming at the offset after each feature decodes the remaining features
+func TestFeatureDecoder(t *testing.T) {
+	const input = `{"type": "FeatureCollection", "features": [
+	{"id": 1},
+	{"id": 2} ,{"id": 3},
+	{"id": 4}
+]}`
+
+	f := writeInput(t, input)
+	dec, base, err := featureDecoder(f, geostore.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, offsets := decodeFeatures(t, dec, base)
+	if len(all) != 4 {
+		t.Fatalf("expected 4 features, got %v", all)
+	}
+	for i, off := range offsets {
+		// The offset is right after the feature
+		if input[off-1] != '}' {
+			t.Errorf("feature %d ends at %d, before %q", i, off, input[off-1:])
+		}
+	}
+
+	for i, off := range offsets {
+		f := writeInput(t, input)
+		dec, base, err := featureDecoder(f, geostore.Checkpoint{Offset: off, Ordinal: int64(i + 1)})
+		if err != nil {
+			t.Fatalf("resuming after feature %d: %v", i, err)
+		}
+		rest, restOffsets := decodeFeatures(t, dec, base)
+		if !slices.Equal(rest, all[i+1:]) || !slices.Equal(restOffsets, offsets[i+1:]) {
+			t.Errorf("resuming after feature %d: got %v at %v, want %v at %v", i, rest, restOffsets, all[i+1:], offsets[i+1:])
+		}
+	}
+
+	// An offset inside a feature is refused
+	if _, _, err := featureDecoder(writeInput(t, input), geostore.Checkpoint{Offset: offsets[0] - 2}); err == nil {
+		t.Error("expected an error resuming inside a feature")
+	}
+}
+
+// TestInputIdentity validates the fingerprint changes with the content of an input of the same size
+func TestInputIdentity(t *testing.T) {
+	a := writeInput(t, `{"features": [{"id": 1}]}`)
+	b := writeInput(t, `{"features": [{"id": 2}]}`)
+	_, fa, err := inputIdentity(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fb, err := inputIdentity(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fa == fb {
+		t.Errorf("expected different fingerprints, got %q", fa)
+	}
+
+	// The offset of the file is left at the start
+	dec, _, err := featureDecoder(a, geostore.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features, _ := decodeFeatures(t, dec, 0); len(features) != 1 {
+		t.Errorf("expected 1 feature after fingerprinting, got %v", features)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// idResolver derives a stable ID for a feature.
+// With a template, the ID is the template output and a feature missing a field
+// it references is rejected. Otherwise the ID property, the feature ID, then a
+// hash of the feature content are tried in order.
+type idResolver struct {
+	prop string
+	tmpl *template.Template
+}
+
+func newIDResolver(prop, tmpl string) (*idResolver, error) {
+	r := &idResolver{prop: prop}
+	if tmpl != "" {
+		t, err := template.New("id").Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id template: %w", err)
+		}
+		r.tmpl = t
+	}
+	return r, nil
+}
+
+func (r *idResolver) resolve(feature geom.GeoJSONFeature) (string, error) {
+	if r.tmpl != nil {
+		var buf bytes.Buffer
+		if err := r.tmpl.Execute(&buf, feature); err != nil {
+			return "", fmt.Errorf("executing id template: %w", err)
+		}
+		// A field present with a null value is printed as <no value>
+		id := buf.String()
+		if id == "" || strings.Contains(id, "<no value>") {
+			return "", fmt.Errorf("id template gave %q, a field is empty", id)
+		}
+		return id, nil
+	}
+
+	if r.prop != "" {
+		if v, ok := feature.Properties[r.prop]; ok && v != nil {
+			if id := fmt.Sprintf("%v", v); id != "" {
+				return id, nil
+			}
+		}
+	}
+
+	if feature.ID != nil {
+		if id := fmt.Sprintf("%v", feature.ID); id != "" {
+			return id, nil
+		}
+	}
+
+	return contentHash(feature)
+}
+
+// contentHash returns a deterministic ID from the geometry and properties,
+// so re-importing the same file yields the same IDs.
+func contentHash(feature geom.GeoJSONFeature) (string, error) {
+	// encoding/json sorts map keys, the encoding is canonical for equal content.
+	b, err := geom.GeoJSONFeature{
+		Geometry:   feature.Geometry,
+		Properties: feature.Properties,
+	}.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// dupPolicy controls what happens when a feature ID is already taken,
+// either by a previous feature of the same import or by an object in the database.
+type dupPolicy string
+
+const (
+	dupError     dupPolicy = "error"
+	dupSkip      dupPolicy = "skip"
+	dupOverwrite dupPolicy = "overwrite"
+	dupSuffix    dupPolicy = "suffix"
+)
+
+func parseDupPolicy(s string) (dupPolicy, error) {
+	switch p := dupPolicy(s); p {
+	case dupError, dupSkip, dupOverwrite, dupSuffix:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown duplicate policy %q (want error, skip, overwrite or suffix)", s)
+}
+
+// dedup applies a dupPolicy to the features of a batch, in import order. An ID is
+// taken when a feature of the batch resolved before got it, or when it exists in
+// the database the batch is checked against: previous batches are committed, only
+// the IDs of the current batch are kept in memory.
+// It is not safe for concurrent use, it is owned by the writer.
+type dedup struct {
+	policy dupPolicy
+	seen   map[string]struct{} // IDs of the current batch
+}
+
+func newDedup(policy dupPolicy) *dedup {
+	return &dedup{policy: policy, seen: make(map[string]struct{})}
+}
+
+// reset starts a new batch, once the current one is committed.
+func (d *dedup) reset() {
+	clear(d.seen)
+}
+
+func (d *dedup) taken(id string, exists func(id string) (bool, error)) (bool, error) {
+	if _, ok := d.seen[id]; ok {
+		return true, nil
+	}
+	return exists(id)
+}
+
+// resolve returns the ID to write the feature under, or ok=false if the feature
+// must be skipped. exists reports whether an ID is in the database, usually from a
+// snapshot shared by the batch. With the error policy a duplicate is returned as
+// an error, the feature is rejected. With the suffix policy, the suffixed ID is
+// checked like the original one: a later feature with that ID is a duplicate too.
+func (d *dedup) resolve(id string, exists func(id string) (bool, error)) (string, bool, error) {
+	taken, err := d.taken(id, exists)
+	if err != nil {
+		return "", false, err
+	}
+	if taken {
+		switch d.policy {
+		case dupError:
+			return "", false, fmt.Errorf("duplicate id %q", id)
+		case dupSkip:
+			return "", false, nil
+		case dupSuffix:
+			base := id
+			for n := 2; taken; n++ {
+				id = fmt.Sprintf("%s-%d", base, n)
+				if taken, err = d.taken(id, exists); err != nil {
+					return "", false, err
+				}
+			}
+		}
+	}
+	d.seen[id] = struct{}{}
+	return id, true, nil
+}
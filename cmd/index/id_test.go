@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	geostore "github.com/akhenakh/geobbolt"
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+func parseFeature(t *testing.T, raw string) geom.GeoJSONFeature {
+	t.Helper()
+	f, err := geostore.ParseFeature([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// TestIDResolver validates the resolution order and the rejection of incomplete template IDs
+func TestIDResolver(t *testing.T) {
+	const point = `"geometry": {"type": "Point", "coordinates": [1, 2]}`
+	full := parseFeature(t, `{"type": "Feature", "id": "f1", `+point+`, "properties": {"country": "FR", "name": "Paris", "ref": 75}}`)
+	partial := parseFeature(t, `{"type": "Feature", "id": "f2", `+point+`, "properties": {"country": "FR"}}`)
+	null := parseFeature(t, `{"type": "Feature", `+point+`, "properties": {"country": "FR", "name": null}}`)
+	anonymous := parseFeature(t, `{"type": "Feature", `+point+`, "properties": {"country": "FR"}}`)
+
+	hash, err := contentHash(anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		prop, tmpl string
+		feature    geom.GeoJSONFeature
+		want       string // Empty when the feature is rejected
+	}{
+		{"template", "", "{{.Properties.country}}/{{.Properties.name}}", full, "FR/Paris"},
+		{"template missing field", "", "{{.Properties.country}}/{{.Properties.name}}", partial, ""},
+		{"template null field", "", "{{.Properties.country}}/{{.Properties.name}}", null, ""},
+		{"property", "ref", "", full, "75"},
+		{"missing property", "ref", "", partial, "f2"},
+		{"feature id", "", "", full, "f1"},
+		{"content hash", "", "", anonymous, hash},
+	}
+	for _, tt := range tests {
+		r, err := newIDResolver(tt.prop, tt.tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := r.resolve(tt.feature)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: expected an error, got %q", tt.name, id)
+		case tt.want != "" && (err != nil || id != tt.want):
+			t.Errorf("%s: got %q, %v, want %q", tt.name, id, err, tt.want)
+		}
+	}
+
+	if _, err := newIDResolver("", "{{.Properties"); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+// TestContentHash validates content hashes only depend on the geometry and the properties
+func TestContentHash(t *testing.T) {
+	a := parseFeature(t, `{"type": "Feature", "id": 1, "geometry": {"type": "Point", "coordinates": [1, 2]}, "properties": {"a": 1, "b": "x"}}`)
+	// Same content, another feature ID and key order
+	same := parseFeature(t, `{"type": "Feature", "id": 2, "geometry": {"type": "Point", "coordinates": [1, 2]}, "properties": {"b": "x", "a": 1}}`)
+	moved := parseFeature(t, `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1, 3]}, "properties": {"a": 1, "b": "x"}}`)
+	changed := parseFeature(t, `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1, 2]}, "properties": {"a": 2, "b": "x"}}`)
+
+	hashes := make([]string, 0, 4)
+	for _, f := range []geom.GeoJSONFeature{a, same, moved, changed} {
+		h, err := contentHash(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(h) != 32 || strings.Trim(h, "0123456789abcdef") != "" {
+			t.Errorf("expected 32 hex digits, got %q", h)
+		}
+		hashes = append(hashes, h)
+	}
+	if hashes[0] != hashes[1] {
+		t.Errorf("equal content hashed differently: %s != %s", hashes[0], hashes[1])
+	}
+	if hashes[0] == hashes[2] || hashes[0] == hashes[3] {
+		t.Errorf("different content hashed the same: %v", hashes)
+	}
+}
+
+// TestDedup validates each duplicate policy against the current batch and the database
+func TestDedup(t *testing.T) {
+	db := map[string]bool{"stored": true}
+	exists := func(id string) (bool, error) { return db[id], nil }
+
+	type step struct {
+		id   string
+		want string // Empty when skipped, "error" when rejected
+	}
+	tests := []struct {
+		policy dupPolicy
+		steps  []step
+	}{
+		{dupError, []step{{"a", "a"}, {"a", "error"}, {"stored", "error"}, {"b", "b"}}},
+		{dupSkip, []step{{"a", "a"}, {"a", ""}, {"stored", ""}}},
+		{dupOverwrite, []step{{"a", "a"}, {"a", "a"}, {"stored", "stored"}}},
+		// A later feature with a suffixed ID is a duplicate of it
+		{dupSuffix, []step{{"a", "a"}, {"a", "a-2"}, {"a", "a-3"}, {"a-2", "a-2-2"}, {"stored", "stored-2"}}},
+	}
+	for _, tt := range tests {
+		d := newDedup(tt.policy)
+		for i, s := range tt.steps {
+			id, ok, err := d.resolve(s.id, exists)
+			got := id
+			switch {
+			case err != nil:
+				got = "error"
+			case !ok:
+				got = ""
+			}
+			if got != s.want {
+				t.Errorf("%s step %d: %q resolved to %q, want %q", tt.policy, i, s.id, got, s.want)
+			}
+		}
+	}
+
+	// Once the batch is committed, its IDs are found in the database
+	d := newDedup(dupError)
+	if _, _, err := d.resolve("c", exists); err != nil {
+		t.Fatal(err)
+	}
+	d.reset()
+	if len(d.seen) != 0 {
+		t.Errorf("expected an empty batch after reset, got %d IDs", len(d.seen))
+	}
+	db["c"] = true
+	if _, _, err := d.resolve("c", exists); err == nil {
+		t.Error("expected a committed ID to be a duplicate")
+	}
+}
@@ -4,20 +4,29 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"runtime"
 	"sync"
 	"time"
 
 	geostore "github.com/akhenakh/geobbolt"
-	"github.com/google/uuid"
-	geom "github.com/peterstace/simplefeatures/geom"
+	"github.com/akhenakh/geobbolt/internal/cmdlog"
 )
 
 // Job represents a single raw feature to be processed
 type Job struct {
 	RawFeature json.RawMessage
+	Ordinal    int64 // Position of the feature in the input
+	Offset     int64 // Input offset right after the feature
+}
+
+// Result is the outcome of a Job, either an entry to write or a failure.
+// The raw feature is kept so failures can be written to the rejects file.
+type Result struct {
+	Job
+	Entry  geostore.IndexEntry
+	Reason string
+	Err    error
 }
 
 func main() {
@@ -25,61 +34,126 @@ func main() {
 	dbFile := flag.String("db", "geo.db", "Output DB file")
 	workers := flag.Int("w", runtime.NumCPU(), "Number of parallel workers")
 	batchSize := flag.Int("batch", 5000, "BoltDB write batch size")
+	idProp := flag.String("id-prop", "", "Property used as feature ID (falls back to the feature id, then a content hash)")
+	idTemplate := flag.String("id-template", "", "Go template for the feature ID, e.g. '{{.Properties.country}}/{{.Properties.name}}'")
+	dupFlag := flag.String("dup", "error", "Duplicate ID policy: error (reject the feature), skip, overwrite or suffix")
+	rejectsFile := flag.String("rejects", "", "Write rejected features with their error as JSON lines to this file")
+	maxErrors := flag.Int("max-errors", -1, "Exit with a non-zero code if more features than this failed (-1 disables)")
+	validate := flag.String("validate", "accept", "Invalid geometry policy: accept, reject or fix")
+	keepOriginal := flag.Bool("keep-original", false, "Store the original geometry as WKB so results return exact coordinates")
+	encoding := flag.String("encoding", "lossless", "Shape encoding: lossless or e7 (compact, vertices snapped to 1e-7 degrees)")
+	propsEncoding := flag.String("props-encoding", "json", "Property encoding: json or cbor (compact, keeps integer types)")
+	compression := flag.String("compression", "none", "Entry compression: none, snappy or zstd")
+	indexLayout := flag.String("index-layout", "terms", "Index layout of a new database: terms, cells (binary cell IDs scanned by range) or compact (cells with sequence numbers instead of IDs)")
+	resume := flag.Bool("resume", false, "Resume from the last checkpoint of this input, skipping the features already handled")
+	logFlags := cmdlog.RegisterFlags()
 	flag.Parse()
+	logger := logFlags.Logger()
+
+	ids, err := newIDResolver(*idProp, *idTemplate)
+	if err != nil {
+		cmdlog.Fatal(logger, "Invalid ID flags", "error", err)
+	}
+	policy, err := parseDupPolicy(*dupFlag)
+	if err != nil {
+		cmdlog.Fatal(logger, "Invalid -dup", "error", err)
+	}
+
+	opts := geostore.DefaultOptions()
+	if opts.Validation, err = geostore.ParseValidationPolicy(*validate); err != nil {
+		cmdlog.Fatal(logger, "Invalid -validate", "error", err)
+	}
+	opts.KeepOriginalGeometry = *keepOriginal
+	if opts.Encoding, err = geostore.ParseShapeEncoding(*encoding); err != nil {
+		cmdlog.Fatal(logger, "Invalid -encoding", "error", err)
+	}
+	if opts.Compression, err = geostore.ParseCompression(*compression); err != nil {
+		cmdlog.Fatal(logger, "Invalid -compression", "error", err)
+	}
+	if opts.PropertyEncoding, err = geostore.ParsePropertyEncoding(*propsEncoding); err != nil {
+		cmdlog.Fatal(logger, "Invalid -props-encoding", "error", err)
+	}
+	if opts.IndexLayout, err = geostore.ParseIndexLayout(*indexLayout); err != nil {
+		cmdlog.Fatal(logger, "Invalid -index-layout", "error", err)
+	}
+	opts.Logger = logger
 
 	start := time.Now()
 
-	store, err := geostore.NewGeoStore(*dbFile)
+	store, err := geostore.NewGeoStoreWithOptions(*dbFile, opts)
 	if err != nil {
-		log.Fatalf("Failed to open db: %v", err)
+		cmdlog.Fatal(logger, "Failed to open db", "path", *dbFile, "error", err)
 	}
 	defer store.Close()
 
 	f, err := os.Open(*inputFile)
 	if err != nil {
-		log.Fatalf("Failed to open input: %v", err)
+		cmdlog.Fatal(logger, "Failed to open input", "path", *inputFile, "error", err)
 	}
 	defer f.Close()
 
+	cpName, fingerprint, err := inputIdentity(f)
+	if err != nil {
+		cmdlog.Fatal(logger, "Failed to stat input", "path", *inputFile, "error", err)
+	}
+	var cp geostore.Checkpoint
+	if *resume {
+		var found bool
+		cp, found, err = store.Checkpoint(cpName)
+		if err != nil {
+			cmdlog.Fatal(logger, "Failed to read checkpoint", "error", err)
+		}
+		if found && cp.Fingerprint != fingerprint {
+			cmdlog.Fatal(logger, "Input changed since the checkpoint, can't resume", "checkpoint", cp.Fingerprint, "input", fingerprint)
+		}
+		if found {
+			logger.Info("Resuming from checkpoint", "features", cp.Ordinal, "offset", cp.Offset, "handled", len(cp.Handled))
+		} else {
+			logger.Info("No checkpoint found, starting from the beginning")
+		}
+	}
+
+	rep, err := newReport(logger, *rejectsFile, *resume)
+	if err != nil {
+		cmdlog.Fatal(logger, "Failed to open rejects file", "path", *rejectsFile, "error", err)
+	}
+
 	// Setup Pipeline
 
 	jobChan := make(chan Job, *workers*2)
-	resultChan := make(chan geostore.IndexEntry, *batchSize)
+	resultChan := make(chan Result, *batchSize)
 	var wg sync.WaitGroup
 
 	// Start Workers (CPU bound)
 
-	fmt.Printf("Starting %d workers...\n", *workers)
+	logger.Info("Starting workers", "workers", *workers)
 	for range *workers {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
 			for job := range jobChan {
-				// We declare the struct inside the loop to avoid Properties map merging
-				// issues if we reused the variable across iterations.
-				var feature geom.GeoJSONFeature
-
-				// Parse once
-				if err := json.Unmarshal(job.RawFeature, &feature); err != nil {
+				// Parse once, integer properties are kept as integers
+				feature, err := geostore.ParseFeature(job.RawFeature)
+				if err != nil {
+					resultChan <- Result{Job: job, Reason: reasonDecode, Err: err}
 					continue
 				}
 
-				id := uuid.New().String()
-				if feature.ID != nil {
-					id = fmt.Sprintf("%v", feature.ID)
-				} else if n, ok := feature.Properties["name"]; ok {
-					id = fmt.Sprintf("%v", n)
+				id, err := ids.resolve(feature)
+				if err != nil {
+					resultChan <- Result{Job: job, Reason: reasonID, Err: err}
+					continue
 				}
 
 				// Heavy Lifting here: S2 math, Encoding
 				// We pass the already parsed feature struct.
 				entry, err := store.PrepareIndexEntry(id, feature)
-				if err == nil {
-					resultChan <- entry
-				} else {
-					// log.Printf("Error processing %s: %v", id, err)
+				if err != nil {
+					resultChan <- Result{Job: job, Reason: prepareReason(err), Err: fmt.Errorf("%s: %w", id, err)}
+					continue
 				}
+				resultChan <- Result{Job: job, Entry: entry}
 			}
 		}()
 	}
@@ -88,61 +162,119 @@ func main() {
 
 	writeDone := make(chan struct{})
 	go func() {
+		pending := make([]Result, 0, *batchSize)
 		batch := make([]geostore.IndexEntry, 0, *batchSize)
+		jobs := make([]Job, 0, *batchSize)
 		count := 0
+		skipped := 0
+		repaired := 0
+		prog := newProgress(cp)
+		seq := newSequencer(cp)
+		dups := newDedup(policy)
+
+		// resolve applies the duplicate policy to the pending results, in import
+		// order as released by seq, and fills the batch with the entries to write. IDs are checked
+		// against a single snapshot of the database for the whole batch.
+		resolve := func(snap *geostore.Snapshot) {
+			for _, res := range pending {
+				id, ok, err := dups.resolve(res.Entry.ID, snap.Has)
+				if err != nil {
+					rep.fail(reasonDuplicate, err, res.RawFeature)
+					continue
+				}
+				if !ok {
+					skipped++
+					continue
+				}
+				entry := res.Entry
+				entry.ID = id
+				if len(entry.Repairs) > 0 {
+					repaired++
+				}
+				batch = append(batch, entry)
+				jobs = append(jobs, res.Job)
+			}
+		}
 
+		// flush commits the pending results along with the checkpoint, they are
+		// counted as handled so the checkpoint covers them.
 		flush := func() {
-			if len(batch) > 0 {
-				if err := store.WriteBatch(batch); err != nil {
-					log.Printf("Batch write error: %v", err)
+			err := store.View(func(snap *geostore.Snapshot) error {
+				resolve(snap)
+				return nil
+			})
+			if err != nil {
+				logger.Error("Duplicate check failed", "entries", len(pending), "error", err)
+				for _, res := range pending {
+					rep.fail(reasonDuplicate, err, res.RawFeature)
 				}
+			}
+			for _, res := range pending {
+				prog.done(res.Ordinal, res.Offset)
+			}
+			if err := store.WriteBatchCheckpoint(batch, cpName, prog.checkpoint(fingerprint)); err != nil {
+				logger.Error("Batch write failed", "entries", len(batch), "error", err)
+				for _, job := range jobs {
+					rep.fail(reasonWrite, err, job.RawFeature)
+				}
+			} else {
 				count += len(batch)
-				batch = batch[:0] // Reset slice
-				fmt.Printf("\rIndexed: %d...", count)
 			}
+			dups.reset()
+			pending = pending[:0]
+			batch = batch[:0] // Reset slice
+			jobs = jobs[:0]
+			logger.Debug("Batch committed", "indexed", count)
 		}
 
-		for entry := range resultChan {
-			batch = append(batch, entry)
-			if len(batch) >= *batchSize {
-				flush()
-			}
+		for res := range resultChan {
+			seq.add(res, func(res Result) {
+				if res.Err != nil {
+					rep.fail(res.Reason, res.Err, res.RawFeature)
+					prog.done(res.Ordinal, res.Offset)
+					return
+				}
+				pending = append(pending, res)
+				if len(pending) >= *batchSize {
+					flush()
+				}
+			})
 		}
 		flush() // Final flush
+		if skipped > 0 {
+			logger.Info("Skipped duplicates", "count", skipped)
+		}
+		if repaired > 0 {
+			logger.Info("Repaired geometries", "count", repaired)
+		}
 		close(writeDone)
 	}()
 
 	// Stream Input (Memory bound)
 
-	dec := json.NewDecoder(f)
-
-	// Locate the "features" array in the stream
-	// This logic assumes standard FeatureCollection structure
-	// { "type": "FeatureCollection", "features": [ ... ] }
-	for {
-		t, err := dec.Token()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if s, ok := t.(string); ok && s == "features" {
-			break
-		}
-	}
-
-	// Read opening bracket of array
-	if _, err := dec.Token(); err != nil {
-		log.Fatal(err)
+	dec, base, err := featureDecoder(f, cp)
+	if err != nil {
+		cmdlog.Fatal(logger, "Failed to read input", "error", err)
 	}
 
 	// Iterate over features array
 	itemCount := 0
+	ordinal := cp.Ordinal
+	var streamErr error
 	for dec.More() {
 		var raw json.RawMessage
 		if err := dec.Decode(&raw); err != nil {
-			log.Printf("Error decoding feature: %v", err)
-			continue
+			// The stream is malformed, the decoder can't resync past this point.
+			// The feature is not marked handled, the checkpoint stays before it.
+			logger.Error("Error decoding feature, stopping", "ordinal", ordinal, "error", err)
+			streamErr = err
+			break
+		}
+		// Handled after the checkpoint watermark before the import stopped
+		if _, ok := cp.Handled[ordinal]; !ok {
+			jobChan <- Job{RawFeature: raw, Ordinal: ordinal, Offset: base + dec.InputOffset()}
 		}
-		jobChan <- Job{RawFeature: raw}
+		ordinal++
 		itemCount++
 	}
 
@@ -151,5 +283,18 @@ func main() {
 	close(resultChan) // Signal writer to stop
 	<-writeDone       // Wait for Disk IO to finish
 
-	fmt.Printf("\nDone. Processed %d items in %v.\n", itemCount, time.Since(start))
+	if streamErr != nil {
+		rep.fail(reasonDecode, streamErr, nil)
+	}
+
+	logger.Info("Done", "processed", itemCount, "duration", time.Since(start))
+	rep.log()
+	if err := rep.Close(); err != nil {
+		logger.Error("Failed to write rejects file", "path", *rejectsFile, "error", err)
+	}
+
+	if *maxErrors >= 0 && rep.total() > *maxErrors {
+		store.Close()
+		cmdlog.Fatal(logger, "Too many features failed", "failed", rep.total(), "allowed", *maxErrors)
+	}
 }
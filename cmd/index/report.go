@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sort"
+
+	geostore "github.com/akhenakh/geobbolt"
+)
+
+// Failure reasons reported in the summary and the rejects file.
+const (
+	reasonDecode      = "decode"
+	reasonID          = "id"
+	reasonEmpty       = "empty_geometry"
+	reasonUnsupported = "unsupported_geometry"
+	reasonInvalid     = "invalid_geometry"
+	reasonPrepare     = "prepare"
+	reasonDuplicate   = "duplicate"
+	reasonWrite       = "write"
+)
+
+// prepareReason classifies a PrepareIndexEntry error.
+func prepareReason(err error) string {
+	switch {
+	case errors.Is(err, geostore.ErrEmptyGeometry):
+		return reasonEmpty
+	case errors.Is(err, geostore.ErrUnsupportedGeometry):
+		return reasonUnsupported
+	case errors.Is(err, geostore.ErrInvalidGeometry):
+		return reasonInvalid
+	}
+	return reasonPrepare
+}
+
+// reject is a line of the rejects file.
+type reject struct {
+	Reason  string          `json:"reason"`
+	Error   string          `json:"error"`
+	Feature json.RawMessage `json:"feature"`
+}
+
+// report counts failures by reason and optionally writes rejected features
+// as JSON lines. It is not safe for concurrent use, it is owned by the writer.
+type report struct {
+	logger   *slog.Logger
+	failures map[string]int
+	f        *os.File
+	w        *bufio.Writer
+	enc      *json.Encoder
+}
+
+// newReport creates the report, when resuming rejects are appended to the existing file.
+func newReport(logger *slog.Logger, rejectsPath string, appendRejects bool) (*report, error) {
+	r := &report{logger: logger, failures: make(map[string]int)}
+	if rejectsPath == "" {
+		return r, nil
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendRejects {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(rejectsPath, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+	r.w = bufio.NewWriter(f)
+	r.enc = json.NewEncoder(r.w)
+	return r, nil
+}
+
+func (r *report) fail(reason string, err error, raw json.RawMessage) {
+	r.failures[reason]++
+	r.logger.Debug("Feature rejected", "reason", reason, "error", err)
+	if r.enc == nil {
+		return
+	}
+	if len(raw) == 0 {
+		raw = json.RawMessage("null")
+	}
+	if err := r.enc.Encode(reject{Reason: reason, Error: err.Error(), Feature: raw}); err != nil {
+		r.logger.Error("Failed to write reject", "error", err)
+	}
+}
+
+// total returns the number of failed features.
+func (r *report) total() int {
+	n := 0
+	for _, c := range r.failures {
+		n += c
+	}
+	return n
+}
+
+// log logs the failure counts by reason.
+func (r *report) log() {
+	if len(r.failures) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(r.failures))
+	for reason := range r.failures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	args := make([]any, 0, len(reasons))
+	for _, reason := range reasons {
+		args = append(args, slog.Int(reason, r.failures[reason]))
+	}
+	r.logger.Warn("Features failed", "total", r.total(), slog.Group("reasons", args...))
+}
+
+func (r *report) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
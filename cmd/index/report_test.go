@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	geostore "github.com/akhenakh/geobbolt"
+)
+
+// readRejects returns the lines of a rejects file.
+func readRejects(t *testing.T, path string) []reject {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var rejects []reject
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r reject
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("invalid reject line %q: %v", s.Text(), err)
+		}
+		rejects = append(rejects, r)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rejects
+}
+
+// TestReport validates the rejects file, appended to when resuming, and the summary
+func TestReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.jsonl")
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	r, err := newReport(logger, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feature := json.RawMessage(`{"type":"Feature","geometry":null}`)
+	r.fail(prepareReason(fmt.Errorf("feature 1: %w", geostore.ErrEmptyGeometry)), geostore.ErrEmptyGeometry, feature)
+	r.fail(reasonDuplicate, errors.New("duplicate id a"), feature)
+	r.fail(reasonDecode, errors.New("unexpected EOF"), nil)
+	r.log()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.total() != 3 {
+		t.Errorf("expected 3 failures, got %d", r.total())
+	}
+	if want := `level=WARN msg="Features failed" total=3 reasons.decode=1 reasons.duplicate=1 reasons.empty_geometry=1`; !strings.Contains(logs.String(), want) {
+		t.Errorf("expected %q in the logs:\n%s", want, logs.String())
+	}
+
+	rejects := readRejects(t, path)
+	if len(rejects) != 3 {
+		t.Fatalf("expected 3 rejects, got %+v", rejects)
+	}
+	if r := rejects[0]; r.Reason != reasonEmpty || r.Error != geostore.ErrEmptyGeometry.Error() || string(r.Feature) != string(feature) {
+		t.Errorf("unexpected reject %+v", r)
+	}
+	// A feature that failed to decode has no raw feature
+	if r := rejects[2]; r.Reason != reasonDecode || string(r.Feature) != "null" {
+		t.Errorf("unexpected reject %+v", r)
+	}
+
+	// Resumed, the rejects of the previous run are kept
+	r, err = newReport(logger, path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.fail(reasonWrite, errors.New("disk full"), feature)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rejects := readRejects(t, path); len(rejects) != 4 || rejects[3].Reason != reasonWrite {
+		t.Errorf("expected the reject appended, got %+v", rejects)
+	}
+	if r.total() != 1 {
+		t.Errorf("expected the failures of this run only, got %d", r.total())
+	}
+
+	// A new run starts a new file
+	r, err = newReport(logger, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rejects := readRejects(t, path); len(rejects) != 0 {
+		t.Errorf("expected an empty rejects file, got %+v", rejects)
+	}
+}
+
+// TestReportWithoutFile validates failures are counted without a rejects file
+func TestReportWithoutFile(t *testing.T) {
+	var logs bytes.Buffer
+	r, err := newReport(slog.New(slog.NewTextHandler(&logs, nil)), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.log()
+	if logs.Len() != 0 {
+		t.Errorf("expected no summary without failures, got %s", logs.String())
+	}
+	r.fail(prepareReason(geostore.ErrInvalidGeometry), geostore.ErrInvalidGeometry, nil)
+	r.fail(prepareReason(errors.New("boom")), errors.New("boom"), nil)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.total() != 2 || r.failures[reasonInvalid] != 1 || r.failures[reasonPrepare] != 1 {
+		t.Errorf("unexpected failures %v", r.failures)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+	"github.com/akhenakh/geobbolt/internal/cmdlog"
+)
+
+func main() {
+	dbFile := flag.String("db", "geo.db", "BoltDB file path")
+	compression := flag.String("compression", "none", "Compression of the upgraded entries: none, snappy or zstd")
+	layout := flag.String("layout", "", "Rebuild the index in this layout after upgrading the entries: terms, cells or compact")
+	logFlags := cmdlog.RegisterFlags()
+	flag.Parse()
+
+	logger := logFlags.Logger()
+
+	opts := geostore.DefaultOptions()
+	var err error
+	if opts.Compression, err = geostore.ParseCompression(*compression); err != nil {
+		cmdlog.Fatal(logger, "Invalid -compression", "error", err)
+	}
+	var indexLayout geostore.IndexLayout
+	if *layout != "" {
+		if indexLayout, err = geostore.ParseIndexLayout(*layout); err != nil {
+			cmdlog.Fatal(logger, "Invalid -layout", "error", err)
+		}
+	}
+	opts.Logger = logger
+
+	start := time.Now()
+
+	store, err := geostore.NewGeoStoreWithOptions(*dbFile, opts)
+	if err != nil {
+		cmdlog.Fatal(logger, "Failed to open db", "path", *dbFile, "error", err)
+	}
+	defer store.Close()
+
+	n, skipped, err := store.Migrate()
+	if err != nil {
+		store.Close()
+		cmdlog.Fatal(logger, "Migration failed", "upgraded", n, "error", err)
+	}
+	logger.Info("Upgraded entries", "count", n, "duration", time.Since(start))
+	if skipped > 0 {
+		logger.Warn("Skipped undecodable entries", "count", skipped)
+	}
+
+	if *layout != "" {
+		start = time.Now()
+		n, err := store.Reindex(indexLayout)
+		if err != nil {
+			store.Close()
+			cmdlog.Fatal(logger, "Reindex failed", "error", err)
+		}
+		logger.Info("Reindexed entries", "count", n, "layout", indexLayout, "duration", time.Since(start))
+	}
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
 	geostore "github.com/akhenakh/geobbolt"
+	"github.com/akhenakh/geobbolt/internal/cmdlog"
 )
 
 func main() {
@@ -17,31 +16,58 @@ func main() {
 	lng := flag.Float64("lng", 0.0, "Longitude")
 	radius := flag.Float64("r", 5000.0, "Search radius in meters")
 	withGeom := flag.Bool("geom", false, "Return geometry in results")
+	propKeys := flag.String("props", "", "Comma separated property keys to return, all when empty")
+	noProps := flag.Bool("no-props", false, "Don't return properties")
+	workers := flag.Int("w", 1, "Number of goroutines refining the candidates")
+	explain := flag.Bool("explain", false, "Print how the query ran: index lookups, candidates and timings")
+	logFlags := cmdlog.RegisterFlags()
 	flag.Parse()
+	logger := logFlags.Logger()
 
 	if *lat == 0 && *lng == 0 {
-		log.Fatal("Please provide -lat and -lng arguments")
+		cmdlog.Fatal(logger, "Please provide -lat and -lng arguments")
 	}
 
 	start := time.Now()
 
 	// 1. Open Store
-	store, err := geostore.NewGeoStore(*dbFile)
+	storeOpts := geostore.DefaultOptions()
+	storeOpts.QueryWorkers = *workers
+	storeOpts.Logger = logger
+	store, err := geostore.NewGeoStoreWithOptions(*dbFile, storeOpts)
 	if err != nil {
-		log.Fatalf("Failed to open db: %v", err)
+		cmdlog.Fatal(logger, "Failed to open db", "path", *dbFile, "error", err)
 	}
 	defer store.Close()
 
 	// 2. Perform Query
-	fmt.Printf("Searching within %.0fm of (%f, %f)...\n", *radius, *lat, *lng)
+	logger.Info("Searching", "lat", *lat, "lng", *lng, "radius", *radius)
 
-	results, err := store.FindClosest(*lat, *lng, *radius, *withGeom)
+	// Properties are printed as stored, no need to decode them
+	opts := geostore.QueryOptions{WithGeometry: *withGeom, RawProperties: true}
+	switch {
+	case *noProps:
+		opts.Properties = []string{}
+	case *propKeys != "":
+		opts.Properties = strings.Split(*propKeys, ",")
+	}
+
+	var ex geostore.Explain
+	if *explain {
+		opts.Explain = &ex
+	}
+
+	results, err := store.FindClosestWithOptions(*lat, *lng, *radius, opts)
 	if err != nil {
-		log.Fatalf("Query failed: %v", err)
+		cmdlog.Fatal(logger, "Query failed", "error", err)
 	}
 
 	duration := time.Since(start)
 
+	if *explain {
+		printExplain(ex)
+	}
+
 	// 3. Display Results
 	if len(results) == 0 {
 		fmt.Println("No results found.")
@@ -58,11 +84,10 @@ func main() {
 	fmt.Println(strings.Repeat("-", len(header)+20))
 
 	for _, item := range results {
-		propsBytes, _ := json.Marshal(item.Properties)
 		line := fmt.Sprintf("%-36s | %-8.1fm | %s",
 			item.ID,
 			item.Distance,
-			string(propsBytes),
+			string(item.RawProperties),
 		)
 		if *withGeom {
 			geoJSON, _ := item.Geometry.MarshalJSON()
@@ -71,3 +96,15 @@ func main() {
 		fmt.Println(line)
 	}
 }
+
+// printExplain prints the explain of the query.
+func printExplain(ex geostore.Explain) {
+	fmt.Printf("Index (%s layout): %d keys scanned in %d lookups, %v\n", ex.Layout, ex.KeysScanned, len(ex.Terms), ex.IndexTime)
+	for _, term := range ex.Terms {
+		fmt.Printf("  %-40s %d\n", term.Term, term.Keys)
+	}
+	fmt.Printf("Candidates: %d interior, %d exterior\n", ex.InteriorCandidates, ex.ExteriorCandidates)
+	fmt.Printf("Culled: %d by the object index, %d by distance\n", ex.CulledByIndex, ex.CulledByDistance)
+	fmt.Printf("Results: %d\n", ex.Results)
+	fmt.Printf("Time: decode %v, refine %v, total %v\n\n", ex.DecodeTime, ex.RefineTime, ex.TotalTime)
+}
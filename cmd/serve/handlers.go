@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+)
+
+// defaultLimit is the number of results returned when the request has no limit.
+const defaultLimit = 100
+
+// earthRadiusKm converts areas on the sphere in steradians to square kilometers.
+const earthRadiusKm = 6371.0
+
+// server serves the store as GeoJSON.
+type server struct {
+	store     *geostore.GeoStore
+	logger    *slog.Logger
+	maxRadius float64 // Meters
+	maxArea   float64 // Square kilometers
+	maxLimit  int
+	maxBody   int64
+}
+
+// handler returns the routes with a timeout per request. The request context is
+// cancelled at the timeout, which stops the query of a request timing out.
+func (s *server) handler(timeout time.Duration) http.Handler {
+	return http.TimeoutHandler(s.routes(), timeout, `{"error":"request timed out"}`)
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /features/{id}", s.getFeature)
+	mux.HandleFunc("PUT /features/{id}", s.putFeature)
+	mux.HandleFunc("DELETE /features/{id}", s.deleteFeature)
+	mux.HandleFunc("GET /nearest", s.nearest)
+	mux.HandleFunc("GET /bbox", s.bbox)
+	mux.HandleFunc("GET /contains", s.contains)
+	return mux
+}
+
+// feature is a GeoJSON feature of a result, geometry is null when not requested.
+// The distance of a query result is a foreign member, properties are as stored.
+type feature struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+	Distance   *float64        `json:"distance,omitempty"`
+}
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+// badRequest is a validation error of the request parameters.
+type badRequest struct{ msg string }
+
+func (e badRequest) Error() string { return e.msg }
+
+func badRequestf(format string, args ...any) error {
+	return badRequest{fmt.Sprintf(format, args...)}
+}
+
+func (s *server) getFeature(w http.ResponseWriter, r *http.Request) {
+	item, err := s.store.Get(r.PathValue("id"))
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	f, err := toFeature(item, false)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+func (s *server) putFeature(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("feature larger than %d bytes", s.maxBody))
+			return
+		}
+		s.error(w, r, badRequestf("reading body: %v", err))
+		return
+	}
+	f, err := geostore.ParseFeature(data)
+	if err != nil {
+		s.error(w, r, badRequestf("invalid geojson: %v", err))
+		return
+	}
+	entry, err := s.store.PrepareIndexEntry(id, f)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if err := s.store.WriteBatch([]geostore.IndexEntry{entry}); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) deleteFeature(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Delete(r.PathValue("id")); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// nearest returns the features within radius meters of lat/lng, closest first.
+func (s *server) nearest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lat, lng, err := latLng(q.Get("lat"), q.Get("lng"))
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	radius, err := strconv.ParseFloat(q.Get("radius"), 64)
+	if err != nil || radius <= 0 || radius > s.maxRadius {
+		s.error(w, r, badRequestf("radius must be a number of meters in ]0, %v]", s.maxRadius))
+		return
+	}
+	s.query(w, r, func(opts geostore.QueryOptions) ([]geostore.StoredItem, error) {
+		return s.store.FindClosestWithOptions(lat, lng, radius, opts)
+	})
+}
+
+// bbox returns the features intersecting bbox=minLng,minLat,maxLng,maxLat, in the
+// GeoJSON order. minLng is greater than maxLng across the antimeridian.
+func (s *server) bbox(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Query().Get("bbox"), ",")
+	if len(parts) != 4 {
+		s.error(w, r, badRequestf("bbox must be minLng,minLat,maxLng,maxLat"))
+		return
+	}
+	minLat, minLng, err := latLng(parts[1], parts[0])
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	maxLat, maxLng, err := latLng(parts[3], parts[2])
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if minLat > maxLat {
+		s.error(w, r, badRequestf("bbox minLat is greater than maxLat"))
+		return
+	}
+	// A wide bbox decodes every object it covers, whatever the limit
+	if area := rectArea(minLat, minLng, maxLat, maxLng); area > s.maxArea {
+		s.error(w, r, badRequestf("bbox covers %.0f km², more than %.0f km²", area, s.maxArea))
+		return
+	}
+	s.query(w, r, func(opts geostore.QueryOptions) ([]geostore.StoredItem, error) {
+		return s.store.FindInRect(minLat, minLng, maxLat, maxLng, opts)
+	})
+}
+
+// contains returns the features covering lat/lng.
+func (s *server) contains(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lat, lng, err := latLng(q.Get("lat"), q.Get("lng"))
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.query(w, r, func(opts geostore.QueryOptions) ([]geostore.StoredItem, error) {
+		return s.store.FindContaining(lat, lng, opts)
+	})
+}
+
+// query runs find with the options of the request: limit, props and geometry,
+// and writes the results as a FeatureCollection.
+func (s *server) query(w http.ResponseWriter, r *http.Request, find func(geostore.QueryOptions) ([]geostore.StoredItem, error)) {
+	q := r.URL.Query()
+	limit := defaultLimit
+	if v := q.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > s.maxLimit {
+			s.error(w, r, badRequestf("limit must be an integer in [1, %d]", s.maxLimit))
+			return
+		}
+	}
+	limit = min(limit, s.maxLimit)
+
+	opts := geostore.QueryOptions{Context: r.Context()}
+	if v := q.Get("geometry"); v != "" {
+		withGeometry, err := strconv.ParseBool(v)
+		if err != nil {
+			s.error(w, r, badRequestf("geometry must be a boolean"))
+			return
+		}
+		opts.WithGeometry = withGeometry
+	}
+	// Absent returns all the properties, empty returns none
+	if q.Has("props") {
+		opts.Properties = []string{}
+		if v := q.Get("props"); v != "" {
+			opts.Properties = strings.Split(v, ",")
+		}
+	}
+
+	results, err := find(opts)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	fc := featureCollection{Type: "FeatureCollection", Features: make([]feature, len(results))}
+	for i, item := range results {
+		f, err := toFeature(item, !opts.WithGeometry)
+		if err != nil {
+			s.error(w, r, err)
+			return
+		}
+		f.Distance = &item.Distance
+		fc.Features[i] = f
+	}
+	writeJSON(w, http.StatusOK, fc)
+}
+
+// toFeature converts a stored item, without its geometry when noGeometry is set.
+func toFeature(item geostore.StoredItem, noGeometry bool) (feature, error) {
+	f := feature{Type: "Feature", ID: item.ID, Geometry: json.RawMessage("null"), Properties: item.Properties}
+	if f.Properties == nil {
+		f.Properties = map[string]any{}
+	}
+	if !noGeometry {
+		g, err := item.Geometry.MarshalJSON()
+		if err != nil {
+			return f, err
+		}
+		f.Geometry = g
+	}
+	return f, nil
+}
+
+// rectArea returns the area in square kilometers of a lat/lng rectangle in
+// degrees, minLng is greater than maxLng across the antimeridian.
+func rectArea(minLat, minLng, maxLat, maxLng float64) float64 {
+	width := maxLng - minLng
+	if width < 0 {
+		width += 360
+	}
+	toRad := math.Pi / 180
+	height := math.Sin(maxLat*toRad) - math.Sin(minLat*toRad)
+	return earthRadiusKm * earthRadiusKm * width * toRad * height
+}
+
+// latLng parses and validates a latitude and a longitude in degrees.
+func latLng(latStr, lngStr string) (lat, lng float64, err error) {
+	lat, err = strconv.ParseFloat(latStr, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, badRequestf("latitude must be a number in [-90, 90], got %q", latStr)
+	}
+	lng, err = strconv.ParseFloat(lngStr, 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return 0, 0, badRequestf("longitude must be a number in [-180, 180], got %q", lngStr)
+	}
+	return lat, lng, nil
+}
+
+// error writes err with its status code, internal errors are logged and not exposed.
+func (s *server) error(w http.ResponseWriter, r *http.Request, err error) {
+	var br badRequest
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		// Timed out or the client went away, nobody reads the response
+		writeError(w, http.StatusServiceUnavailable, "request timed out")
+	case errors.As(err, &br):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, geostore.ErrNotFound):
+		writeError(w, http.StatusNotFound, "feature not found")
+	case errors.Is(err, geostore.ErrEmptyGeometry),
+		errors.Is(err, geostore.ErrUnsupportedGeometry),
+		errors.Is(err, geostore.ErrInvalidGeometry):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		s.logger.Error("Request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// writeJSON writes v as GeoJSON, unless a content type is already set.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/geo+json")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
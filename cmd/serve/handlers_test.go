@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+)
+
+// newTestServer returns a server over a new store holding a square and a point
+// with a stored "distance" property.
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	store, err := geostore.NewGeoStoreWithOptions(filepath.Join(t.TempDir(), "geo.db"), geostore.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	features := map[string]string{
+		"square": `{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[-79.4, 43.6], [-79.3, 43.6], [-79.3, 43.7], [-79.4, 43.7], [-79.4, 43.6]]]}, "properties": {"name": "square"}}`,
+		"trail":  `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [-79.2, 43.65]}, "properties": {"name": "trail", "distance": "10k"}}`,
+	}
+	for id, f := range features {
+		if err := store.Put(id, []byte(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &server{
+		store:     store,
+		logger:    slog.New(slog.DiscardHandler),
+		maxRadius: 50000,
+		maxArea:   10000,
+		maxLimit:  10,
+		maxBody:   1 << 10,
+	}
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestHandlersValidation validates the parameters are checked, with the limits of the server
+func TestHandlersValidation(t *testing.T) {
+	h := newTestServer(t).handler(time.Minute)
+
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{"GET", "/nearest?lat=43.65&lng=-79.35&radius=1000", "", http.StatusOK},
+		{"GET", "/nearest?lat=91&lng=-79.35&radius=1000", "", http.StatusBadRequest},
+		{"GET", "/nearest?lat=43.65&lng=x&radius=1000", "", http.StatusBadRequest},
+		{"GET", "/nearest?lat=43.65&lng=-79.35", "", http.StatusBadRequest},
+		{"GET", "/nearest?lat=43.65&lng=-79.35&radius=-1", "", http.StatusBadRequest},
+		{"GET", "/nearest?lat=43.65&lng=-79.35&radius=50001", "", http.StatusBadRequest}, // Over max radius
+		{"GET", "/nearest?lat=43.65&lng=-79.35&radius=1000&limit=0", "", http.StatusBadRequest},
+		{"GET", "/nearest?lat=43.65&lng=-79.35&radius=1000&limit=11", "", http.StatusBadRequest},
+		{"GET", "/nearest?lat=43.65&lng=-79.35&radius=1000&geometry=maybe", "", http.StatusBadRequest},
+		{"GET", "/bbox?bbox=-79.5,43.5,-79.1,43.8", "", http.StatusOK},
+		{"GET", "/bbox?bbox=-79.5,43.5,-79.1", "", http.StatusBadRequest},
+		{"GET", "/bbox?bbox=-79.5,43.8,-79.1,43.5", "", http.StatusBadRequest},
+		{"GET", "/bbox?bbox=-180,-90,180,90", "", http.StatusBadRequest}, // Over max area
+		{"GET", "/contains?lat=43.65&lng=-79.35", "", http.StatusOK},
+		{"GET", "/contains?lat=43.65", "", http.StatusBadRequest},
+		{"GET", "/features/square", "", http.StatusOK},
+		{"GET", "/features/missing", "", http.StatusNotFound},
+		{"PUT", "/features/bad", "{", http.StatusBadRequest},
+		{"PUT", "/features/empty", `{"type": "Feature", "geometry": {"type": "Point", "coordinates": []}}`, http.StatusUnprocessableEntity},
+		{"PUT", "/features/large", strings.Repeat(" ", 2<<10), http.StatusRequestEntityTooLarge},
+		{"PUT", "/features/p", `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1, 2]}}`, http.StatusNoContent},
+		{"DELETE", "/features/p", "", http.StatusNoContent},
+		{"DELETE", "/features/p", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := do(t, h, tt.method, tt.target, tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: got %d %s, want %d", tt.method, tt.target, rec.Code, rec.Body, tt.want)
+		}
+		if rec.Code >= 400 && rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: error with content type %q", tt.method, tt.target, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// TestHandlersNearest validates the results, their distance and the stored properties
+func TestHandlersNearest(t *testing.T) {
+	h := newTestServer(t).handler(time.Minute)
+
+	rec := do(t, h, "GET", "/nearest?lat=43.65&lng=-79.22&radius=10000&geometry=true", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/geo+json" {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	var fc featureCollection
+	if err := json.Unmarshal(rec.Body.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+	if len(fc.Features) != 2 {
+		t.Fatalf("expected 2 features, got %s", rec.Body)
+	}
+	for _, f := range fc.Features {
+		if f.Distance == nil || string(f.Geometry) == "null" {
+			t.Errorf("%s: expected a distance and a geometry, got %+v", f.ID, f)
+		}
+	}
+	// The stored property is kept, the distance is a member of the feature
+	if trail := fc.Features[0]; trail.ID != "trail" || trail.Properties["distance"] != "10k" || *trail.Distance > 5000 {
+		t.Errorf("unexpected first feature %+v", trail)
+	}
+
+	rec = do(t, h, "GET", "/nearest?lat=43.65&lng=-79.22&radius=10000&limit=1&props=", "")
+	var limited featureCollection
+	if err := json.Unmarshal(rec.Body.Bytes(), &limited); err != nil {
+		t.Fatal(err)
+	}
+	if len(limited.Features) != 1 || len(limited.Features[0].Properties) != 0 || string(limited.Features[0].Geometry) != "null" {
+		t.Errorf("expected a feature without properties nor geometry, got %s", rec.Body)
+	}
+}
+
+// TestHandlersTimeout validates a request timing out stops its query
+func TestHandlersTimeout(t *testing.T) {
+	s := newTestServer(t)
+	rec := do(t, s.handler(time.Nanosecond), "GET", "/nearest?lat=43.65&lng=-79.35&radius=1000", "")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "timed out") {
+		t.Errorf("expected a timeout, got %d %s", rec.Code, rec.Body)
+	}
+
+	// The query of the request is stopped with the request context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/nearest?lat=43.65&lng=-79.35&radius=1000", nil).WithContext(ctx)
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the cancelled query to fail, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestServeShutdown validates the requests in flight complete on shutdown
+func TestServeShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, lis, time.Minute, slog.New(slog.DiscardHandler)) }()
+
+	resp := make(chan int, 1)
+	go func() {
+		r, err := http.Get("http://" + lis.Addr().String())
+		if err != nil {
+			resp <- 0
+			return
+		}
+		r.Body.Close()
+		resp <- r.StatusCode
+	}()
+	<-started
+	cancel()
+
+	select {
+	case err := <-served:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if code := <-resp; code != http.StatusNoContent {
+		t.Errorf("in flight request got %d", code)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if _, err := http.Get("http://" + lis.Addr().String()); err == nil {
+		t.Error("expected the server to be closed")
+	}
+}
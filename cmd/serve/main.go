@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+	geogrpc "github.com/akhenakh/geobbolt/grpc"
+	"github.com/akhenakh/geobbolt/internal/cmdlog"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	dbFile := flag.String("db", "geo.db", "BoltDB file path")
+	addr := flag.String("addr", ":8080", "HTTP listen address")
+	grpcAddr := flag.String("grpc-addr", "", "gRPC listen address, disabled when empty")
+	timeout := flag.Duration("timeout", 10*time.Second, "Maximum duration of a request")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Maximum wait for the requests in flight on shutdown")
+	maxRadius := flag.Float64("max-radius", 50000, "Maximum search radius in meters")
+	maxArea := flag.Float64("max-area", 10000, "Maximum area of a bbox query in square kilometers")
+	maxLimit := flag.Int("max-limit", 1000, "Maximum number of results per request")
+	maxBody := flag.Int64("max-body", 10<<20, "Maximum size in bytes of a feature written with PUT")
+	workers := flag.Int("w", 1, "Number of goroutines refining the candidates of a query")
+	logFlags := cmdlog.RegisterFlags()
+	flag.Parse()
+	logger := logFlags.Logger()
+
+	opts := geostore.DefaultOptions()
+	opts.QueryWorkers = *workers
+	opts.Logger = logger
+	store, err := geostore.NewGeoStoreWithOptions(*dbFile, opts)
+	if err != nil {
+		cmdlog.Fatal(logger, "Failed to open db", "path", *dbFile, "error", err)
+	}
+	defer store.Close()
+
+	s := &server{
+		store:     store,
+		logger:    logger,
+		maxRadius: *maxRadius,
+		maxArea:   *maxArea,
+		maxLimit:  *maxLimit,
+		maxBody:   *maxBody,
+	}
+	srv := &http.Server{
+		Handler:           s.handler(*timeout),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       *timeout,
+		WriteTimeout:      *timeout + 5*time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		store.Close()
+		cmdlog.Fatal(logger, "Failed to listen", "addr", *addr, "error", err)
+	}
+
+	// A failing gRPC server shuts the HTTP server down too
+	grpcErr := make(chan error, 1)
+	var grpcSrv *grpc.Server
+	if *grpcAddr != "" {
+		glis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			store.Close()
+			cmdlog.Fatal(logger, "Failed to listen", "addr", *grpcAddr, "error", err)
+		}
+		grpcSrv = grpc.NewServer()
+		geogrpc.RegisterGeoStoreServer(grpcSrv, geogrpc.NewServerWithOptions(store, geogrpc.ServerOptions{
+			MaxRadius: *maxRadius,
+			Logger:    logger,
+		}))
+		go func() {
+			logger.Info("Listening for gRPC", "addr", *grpcAddr)
+			if err := grpcSrv.Serve(glis); err != nil {
+				grpcErr <- err
+				stop()
+			}
+		}()
+	}
+
+	logger.Info("Listening", "addr", *addr, "db", *dbFile)
+	err = serve(ctx, srv, lis, *shutdownTimeout, logger)
+	if grpcSrv != nil {
+		stopGRPC(grpcSrv, *shutdownTimeout)
+	}
+	select {
+	case gerr := <-grpcErr:
+		err = errors.Join(err, gerr)
+	default:
+	}
+	if err != nil {
+		store.Close()
+		cmdlog.Fatal(logger, "Server failed", "error", err)
+	}
+}
+
+// serve serves srv on lis until ctx is done, then shuts it down gracefully,
+// waiting up to shutdownTimeout for the requests in flight.
+func serve(ctx context.Context, srv *http.Server, lis net.Listener, shutdownTimeout time.Duration, logger *slog.Logger) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(lis) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Info("Shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		logger.Error("Shutdown incomplete", "error", err)
+		srv.Close()
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// stopGRPC stops srv gracefully, closing the connections left after timeout.
+func stopGRPC(srv *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		srv.Stop()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+	"github.com/akhenakh/geobbolt/internal/cmdlog"
+)
+
+func main() {
+	dbFile := flag.String("db", "geo.db", "BoltDB file path")
+	asJSON := flag.Bool("json", false, "Print the statistics as JSON")
+	logFlags := cmdlog.RegisterFlags()
+	flag.Parse()
+
+	logger := logFlags.Logger()
+
+	start := time.Now()
+
+	// Read only, the store is not modified and other readers can open it too
+	opts := geostore.DefaultOptions()
+	opts.ReadOnly = true
+	opts.Logger = logger
+	store, err := geostore.NewGeoStoreWithOptions(*dbFile, opts)
+	if err != nil {
+		cmdlog.Fatal(logger, "Failed to open db", "path", *dbFile, "error", err)
+	}
+	defer store.Close()
+
+	stats, err := store.Stats()
+	if err != nil {
+		store.Close()
+		cmdlog.Fatal(logger, "Failed to compute stats", "error", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			store.Close()
+			cmdlog.Fatal(logger, "Failed to write stats", "error", err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Printf("Objects: %d\n\n", stats.Objects)
+	fmt.Fprintln(w, "Geometry type\tObjects\t")
+	for _, typ := range slices.Sorted(maps.Keys(stats.GeometryTypes)) {
+		fmt.Fprintf(w, "%s\t%d\t\n", typ, stats.GeometryTypes[typ])
+	}
+	w.Flush()
+
+	s := stats.BlobSizes
+	fmt.Printf("\nBlob sizes: total %s, mean %s\n", formatBytes(s.Total), formatBytes(int64(s.Mean)))
+	fmt.Fprintln(w, "Min\tP50\tP90\tP99\tMax\t")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", formatBytes(s.Min), formatBytes(s.P50), formatBytes(s.P90), formatBytes(s.P99), formatBytes(s.Max))
+	w.Flush()
+
+	fmt.Printf("\nIndex: %s layout, %d keys\n", stats.IndexLayout, stats.IndexKeys)
+	fmt.Fprintln(w, "Level\tInterior\tExterior\tAncestors\t")
+	for _, level := range stats.IndexLevels {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t\n", level.Level, level.Interior, level.Exterior, level.Ancestors)
+	}
+	w.Flush()
+
+	fmt.Printf("\nFile: %s, %d free pages\n", formatBytes(stats.FileSize), stats.FreePages)
+	fmt.Fprintln(w, "Bucket\tKeys\tDepth\tBranch pages\tLeaf pages\tLeaf in use\tLeaf allocated\tInline\t")
+	for _, name := range slices.Sorted(maps.Keys(stats.Buckets)) {
+		b := stats.Buckets[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t\n", name, b.KeyN, b.Depth, b.BranchPageN,
+			b.LeafPageN, formatBytes(b.LeafInuse), formatBytes(b.LeafAlloc), formatBytes(b.InlineBucketInuse))
+	}
+	w.Flush()
+
+	fmt.Printf("\nScanned in %v\n", time.Since(start))
+}
+
+// formatBytes formats a size in bytes with a binary unit.
+func formatBytes[T int | int64](n T) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := int64(n) / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
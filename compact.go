@@ -0,0 +1,219 @@
+package geostore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/golang/geo/s2"
+)
+
+// ShapeEncoding selects how shapes are encoded in the blobs.
+type ShapeEncoding int
+
+const (
+	// EncodingLossless stores every vertex as an s2.Point, three float64s.
+	EncodingLossless ShapeEncoding = iota
+
+	// EncodingE7 snaps vertices to 1e-7 degrees (about 1cm) and stores them as
+	// delta encoded varints, usually 2 to 6 bytes per vertex instead of 24.
+	EncodingE7
+)
+
+func (e ShapeEncoding) String() string {
+	switch e {
+	case EncodingLossless:
+		return "lossless"
+	case EncodingE7:
+		return "e7"
+	}
+	return fmt.Sprintf("ShapeEncoding(%d)", int(e))
+}
+
+// ParseShapeEncoding parses "lossless" or "e7".
+func ParseShapeEncoding(s string) (ShapeEncoding, error) {
+	for _, e := range []ShapeEncoding{EncodingLossless, EncodingE7} {
+		if e.String() == s {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown shape encoding %q (want lossless or e7)", s)
+}
+
+// snapE7 returns the point at the E7 lat/lng closest to p, as decoded from the compact encoding.
+func snapE7(p s2.Point) s2.Point {
+	lat, lng := toE7(p)
+	return fromE7(lat, lng)
+}
+
+func toE7(p s2.Point) (int64, int64) {
+	ll := s2.LatLngFromPoint(p)
+	return int64(math.Round(ll.Lat.Degrees() * 1e7)), int64(math.Round(ll.Lng.Degrees() * 1e7))
+}
+
+func fromE7(lat, lng int64) s2.Point {
+	return s2.PointFromLatLng(s2.LatLngFromDegrees(float64(lat)/1e7, float64(lng)/1e7))
+}
+
+// snapShapes snaps the vertices of shapes to E7, so the shapes indexed and covered
+// are exactly the ones decoded later. Vertices collapsing onto their predecessor are removed.
+func snapShapes(shapes []s2.Shape) []s2.Shape {
+	out := make([]s2.Shape, 0, len(shapes))
+	for _, s := range shapes {
+		switch v := s.(type) {
+		case *s2.PointVector:
+			pv := make(s2.PointVector, len(*v))
+			for i, pt := range *v {
+				pv[i] = snapE7(pt)
+			}
+			out = append(out, &pv)
+		case *s2.Polyline:
+			pl := s2.Polyline(snapVertices(*v, false))
+			out = append(out, &pl)
+		case *s2.Polygon:
+			var loops []*s2.Loop
+			for _, l := range v.Loops() {
+				// Loops are kept in their polygon order, normalized, ready for PolygonFromLoops
+				if pts := snapVertices(l.Vertices(), true); len(pts) >= 3 {
+					loops = append(loops, s2.LoopFromPoints(pts))
+				}
+			}
+			out = append(out, s2.PolygonFromLoops(loops))
+		default:
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func snapVertices(pts []s2.Point, ring bool) []s2.Point {
+	out := make([]s2.Point, 0, len(pts))
+	for _, pt := range pts {
+		q := snapE7(pt)
+		if len(out) > 0 && out[len(out)-1] == q {
+			continue
+		}
+		out = append(out, q)
+	}
+	if ring && len(out) > 1 && out[len(out)-1] == out[0] {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// e7Writer writes points as varint deltas of their E7 lat/lng from the previous point.
+type e7Writer struct {
+	buf              *bytes.Buffer
+	prevLat, prevLng int64
+	b                [binary.MaxVarintLen64]byte
+}
+
+func (w *e7Writer) uvarint(v uint64) {
+	n := binary.PutUvarint(w.b[:], v)
+	w.buf.Write(w.b[:n])
+}
+
+func (w *e7Writer) point(p s2.Point) {
+	lat, lng := toE7(p)
+	n := binary.PutVarint(w.b[:], lat-w.prevLat)
+	w.buf.Write(w.b[:n])
+	n = binary.PutVarint(w.b[:], lng-w.prevLng)
+	w.buf.Write(w.b[:n])
+	w.prevLat, w.prevLng = lat, lng
+}
+
+// encodeE7Shape encodes a shape in the compact format, returning its type byte.
+// Points and polylines: [NumPoints][Deltas...], polygons: [NumLoops]([NumVertices][Deltas...])...
+func encodeE7Shape(buf *bytes.Buffer, shape s2.Shape) (byte, error) {
+	w := &e7Writer{buf: buf}
+	switch s := shape.(type) {
+	case *s2.PointVector:
+		w.uvarint(uint64(len(*s)))
+		for _, pt := range *s {
+			w.point(pt)
+		}
+		return typePointVectorE7, nil
+	case *s2.Polyline:
+		w.uvarint(uint64(len(*s)))
+		for _, pt := range *s {
+			w.point(pt)
+		}
+		return typePolylineE7, nil
+	case *s2.Polygon:
+		w.uvarint(uint64(s.NumLoops()))
+		for _, l := range s.Loops() {
+			w.uvarint(uint64(l.NumVertices()))
+			for _, pt := range l.Vertices() {
+				w.point(pt)
+			}
+		}
+		return typePolygonE7, nil
+	}
+	return 0, fmt.Errorf("unsupported shape type for encoding: %T", shape)
+}
+
+// e7Reader reads points written by e7Writer.
+type e7Reader struct {
+	r                io.ByteReader
+	prevLat, prevLng int64
+}
+
+func (r *e7Reader) points() ([]s2.Point, error) {
+	count, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	pts := make([]s2.Point, count)
+	for i := range pts {
+		dlat, err := binary.ReadVarint(r.r)
+		if err != nil {
+			return nil, err
+		}
+		dlng, err := binary.ReadVarint(r.r)
+		if err != nil {
+			return nil, err
+		}
+		r.prevLat += dlat
+		r.prevLng += dlng
+		pts[i] = fromE7(r.prevLat, r.prevLng)
+	}
+	return pts, nil
+}
+
+// decodeE7Shape decodes a shape encoded by encodeE7Shape.
+func decodeE7Shape(r io.ByteReader, typ byte) (s2.Shape, error) {
+	er := &e7Reader{r: r}
+	switch typ {
+	case typePointVectorE7:
+		pts, err := er.points()
+		if err != nil {
+			return nil, err
+		}
+		pv := s2.PointVector(pts)
+		return &pv, nil
+	case typePolylineE7:
+		pts, err := er.points()
+		if err != nil {
+			return nil, err
+		}
+		pl := s2.Polyline(pts)
+		return &pl, nil
+	case typePolygonE7:
+		numLoops, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		loops := make([]*s2.Loop, numLoops)
+		for i := range loops {
+			pts, err := er.points()
+			if err != nil {
+				return nil, err
+			}
+			loops[i] = s2.LoopFromPoints(pts)
+		}
+		return s2.PolygonFromLoops(loops), nil
+	}
+	return nil, fmt.Errorf("unknown compact shape type %d", typ)
+}
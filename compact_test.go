@@ -0,0 +1,84 @@
+package geostore
+
+import (
+	"math"
+	"testing"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// circleRing returns a closed ring of n vertices around lng/lat, with a Z value per vertex
+func circleRing(lng, lat, radius float64, n int, clockwise bool) geom.LineString {
+	coords := make([]float64, 0, (n+1)*3)
+	for i := 0; i <= n; i++ {
+		a := 2 * math.Pi * float64(i%n) / float64(n)
+		if clockwise {
+			a = -a
+		}
+		coords = append(coords, lng+radius*math.Cos(a), lat+radius*math.Sin(a), float64(i%n))
+	}
+	return geom.NewLineString(geom.NewSequence(coords, geom.DimXYZ))
+}
+
+// TestCompactEncoding validates the E7 encoding against the lossless one
+func TestCompactEncoding(t *testing.T) {
+	park := geom.NewPolygon([]geom.LineString{
+		circleRing(-79.38, 43.65, 0.05, 500, false),
+		circleRing(-79.38, 43.65, 0.01, 100, true),
+	}).AsGeometry()
+
+	sizes := make(map[ShapeEncoding]int)
+	for _, enc := range []ShapeEncoding{EncodingLossless, EncodingE7} {
+		opts := DefaultOptions()
+		opts.Encoding = enc
+		store := newTestStore(t, opts)
+
+		entry, err := store.PrepareIndexEntry("park", geom.GeoJSONFeature{Geometry: park})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[enc] = len(entry.Blob)
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Inside the shell, outside the hole
+		results, err := store.FindClosest(43.65, -79.35, 10, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Distance != 0 {
+			t.Errorf("%s: expected to be inside the polygon, got %+v", enc, results)
+		}
+		// Inside the hole
+		results, err = store.FindClosest(43.65, -79.38, 10, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Errorf("%s: expected no result inside the hole, got %+v", enc, results)
+		}
+
+		item, err := store.Get("park")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !geom.ExactEquals(item.Geometry.Force2D(), park.Force2D(), geom.ToleranceXY(1e-7)) {
+			t.Errorf("%s: geometry differs beyond E7 precision", enc)
+		}
+		// Z values must still follow their vertices once snapped
+		if !geom.ExactEquals(item.Geometry, park, geom.ToleranceXY(1e-7)) {
+			t.Errorf("%s: Z values do not match", enc)
+		}
+
+		// Overwriting finds the terms generated from the snapped shapes
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Logf("Blob sizes: lossless %d bytes, e7 %d bytes", sizes[EncodingLossless], sizes[EncodingE7])
+	if sizes[EncodingE7]*2 > sizes[EncodingLossless] {
+		t.Errorf("Expected the E7 blob to be less than half the lossless one, got %d vs %d", sizes[EncodingE7], sizes[EncodingLossless])
+	}
+}
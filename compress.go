@@ -0,0 +1,97 @@
+package geostore
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how entry payloads (properties, geometry info, shapes and index) are compressed.
+type Compression byte
+
+const (
+	// CompressionNone stores payloads as is.
+	CompressionNone Compression = 0
+
+	// CompressionSnappy favors speed, decoding costs little on the query path.
+	CompressionSnappy Compression = 1
+
+	// CompressionZstd favors size.
+	CompressionZstd Compression = 2
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Compression(%d)", byte(c))
+}
+
+// ParseCompression parses "none", "snappy" or "zstd".
+func ParseCompression(s string) (Compression, error) {
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown compression %q (want none, snappy or zstd)", s)
+}
+
+// zstd encoders and decoders are safe for concurrent EncodeAll/DecodeAll calls, they are shared.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// compress appends the compressed payload to dst.
+func compress(dst, payload []byte, c Compression) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return append(dst, payload...), nil
+	case CompressionSnappy:
+		return append(dst, snappy.Encode(nil, payload)...), nil
+	case CompressionZstd:
+		enc, _, err := zstdCodecs()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(payload, dst), nil
+	}
+	return nil, fmt.Errorf("unknown compression %d", byte(c))
+}
+
+// decompress returns the payload, data itself when not compressed.
+func decompress(data []byte, c Compression) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	case CompressionZstd:
+		_, dec, err := zstdCodecs()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("unknown compression %d", byte(c))
+}
@@ -0,0 +1,111 @@
+package geostore
+
+import (
+	"fmt"
+	"testing"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// parkFeature returns a polygon with a hole and some properties, large enough for compression to matter
+func parkFeature() geom.GeoJSONFeature {
+	return geom.GeoJSONFeature{
+		Geometry: geom.NewPolygon([]geom.LineString{
+			circleRing(-79.38, 43.65, 0.05, 500, false),
+			circleRing(-79.38, 43.65, 0.01, 100, true),
+		}).AsGeometry(),
+		Properties: map[string]any{"name": "High Park", "kind": "park", "description": "A large municipal park"},
+	}
+}
+
+// TestCompressionMixed writes entries with every compression in the same store and reads them all back
+func TestCompressionMixed(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	feature := parkFeature()
+
+	compressions := []Compression{CompressionNone, CompressionSnappy, CompressionZstd}
+	for _, c := range compressions {
+		store.opts.Compression = c
+		entry, err := store.PrepareIndexEntry(c.String(), feature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Compression(entry.Blob[len(blobMagic)+1]) != c {
+			t.Errorf("%s: header compression byte is %d", c, entry.Blob[len(blobMagic)+1])
+		}
+		t.Logf("%s: %d bytes", c, len(entry.Blob))
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := store.FindClosest(43.65, -79.35, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(compressions) {
+		t.Fatalf("expected %d results, got %d", len(compressions), len(results))
+	}
+	for _, r := range results {
+		if r.Distance != 0 || r.Properties["name"] != "High Park" || r.Geometry.IsEmpty() {
+			t.Errorf("%s: unexpected result %+v", r.ID, r)
+		}
+	}
+
+	for _, c := range compressions {
+		item, err := store.Get(c.String())
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if !geom.ExactEquals(item.Geometry, feature.Geometry, geom.ToleranceXY(1e-9)) {
+			t.Errorf("%s: geometry differs", c)
+		}
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		got, err := ParseCompression(c.String())
+		if err != nil || got != c {
+			t.Errorf("ParseCompression(%q) = %v, %v", c, got, err)
+		}
+	}
+	if _, err := ParseCompression("gzip"); err == nil {
+		t.Error("expected an error for an unknown compression")
+	}
+}
+
+// BenchmarkCompression reports the blob size and the query latency of each compression
+func BenchmarkCompression(b *testing.B) {
+	feature := parkFeature()
+	for _, enc := range []ShapeEncoding{EncodingLossless, EncodingE7} {
+		for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+			b.Run(fmt.Sprintf("%s/%s", enc, c), func(b *testing.B) {
+				opts := DefaultOptions()
+				opts.Encoding = enc
+				opts.Compression = c
+				store := newTestStore(b, opts)
+
+				entries := make([]IndexEntry, 50)
+				for i := range entries {
+					entry, err := store.PrepareIndexEntry(fmt.Sprintf("park-%d", i), feature)
+					if err != nil {
+						b.Fatal(err)
+					}
+					entries[i] = entry
+				}
+				if err := store.WriteBatch(entries); err != nil {
+					b.Fatal(err)
+				}
+
+				b.ResetTimer()
+				for range b.N {
+					if _, err := store.FindClosest(43.65, -79.35, 10, true); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(entries[0].Blob)), "bytes/entry")
+			})
+		}
+	}
+}
@@ -22,20 +22,30 @@ func (p PointRegion) CellUnionBound() []s2.CellID       { return p.CapBound().Ce
 // geomToS2 converts geometry to:
 // 1. A slice of s2.Shape (for the ShapeIndex)
 // 2. A slice of s2.Region (for TermIndexing)
-func geomToS2(g geom.Geometry) ([]s2.Shape, []s2.Region, error) {
+// Geometries are checked and repaired by v on the way.
+func geomToS2(g geom.Geometry, v *validator) ([]s2.Shape, []s2.Region, error) {
 	switch g.Type() {
 	case geom.TypePoint:
-		pt := pointToS2(g.MustAsPoint())
+		pt, err := pointToS2(g.MustAsPoint(), v, "point")
+		if err != nil {
+			return nil, nil, err
+		}
 		// PointVector implements Shape
 		pv := s2.PointVector{pt}
 		return []s2.Shape{&pv}, []s2.Region{PointRegion{pt}}, nil
 
 	case geom.TypeLineString:
-		ls := lineStringToS2(g.MustAsLineString())
+		ls, err := lineStringToS2(g.MustAsLineString(), v, "line")
+		if err != nil {
+			return nil, nil, err
+		}
 		return []s2.Shape{ls}, []s2.Region{ls}, nil
 
 	case geom.TypePolygon:
-		poly := polygonToS2(g.MustAsPolygon())
+		poly, err := polygonToS2(g.MustAsPolygon(), v)
+		if err != nil {
+			return nil, nil, err
+		}
 		return []s2.Shape{poly}, []s2.Region{poly}, nil
 
 	case geom.TypeMultiPoint:
@@ -44,7 +54,10 @@ func geomToS2(g geom.Geometry) ([]s2.Shape, []s2.Region, error) {
 		pts := make(s2.PointVector, n)
 		regions := make([]s2.Region, n)
 		for i := 0; i < n; i++ {
-			pt := pointToS2(mp.PointN(i))
+			pt, err := pointToS2(mp.PointN(i), v, fmt.Sprintf("point %d", i))
+			if err != nil {
+				return nil, nil, err
+			}
 			pts[i] = pt
 			regions[i] = PointRegion{pt}
 		}
@@ -57,111 +70,288 @@ func geomToS2(g geom.Geometry) ([]s2.Shape, []s2.Region, error) {
 		shapes := make([]s2.Shape, n)
 		regions := make([]s2.Region, n)
 		for i := 0; i < n; i++ {
-			pl := lineStringToS2(ml.LineStringN(i))
+			pl, err := lineStringToS2(ml.LineStringN(i), v, fmt.Sprintf("line %d", i))
+			if err != nil {
+				return nil, nil, err
+			}
 			shapes[i] = pl
 			regions[i] = pl
 		}
 		return shapes, regions, nil
 
 	case geom.TypeMultiPolygon:
-		poly := multiPolygonToS2(g.MustAsMultiPolygon())
+		poly, err := multiPolygonToS2(g.MustAsMultiPolygon(), v)
+		if err != nil {
+			return nil, nil, err
+		}
 		return []s2.Shape{poly}, []s2.Region{poly}, nil
 
+	case geom.TypeGeometryCollection:
+		// Members are flattened, each keeps its own shapes and regions
+		gc := g.MustAsGeometryCollection()
+		var shapes []s2.Shape
+		var regions []s2.Region
+		n := gc.NumGeometries()
+		for i := 0; i < n; i++ {
+			member := gc.GeometryN(i)
+			if member.IsEmpty() {
+				continue
+			}
+			memberShapes, memberRegions, err := geomToS2(member, v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("geometry %d: %w", i, err)
+			}
+			shapes = append(shapes, memberShapes...)
+			regions = append(regions, memberRegions...)
+		}
+		return shapes, regions, nil
+
 	default:
-		return nil, nil, fmt.Errorf("unsupported geometry type: %s", g.Type())
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedGeometry, g.Type())
+	}
+}
+
+// WKB geometry type codes, recorded in blobs to rebuild the ingested geometry type.
+const (
+	wkbUnknown            byte = 0
+	wkbPoint              byte = 1
+	wkbLineString         byte = 2
+	wkbPolygon            byte = 3
+	wkbMultiPoint         byte = 4
+	wkbMultiLineString    byte = 5
+	wkbMultiPolygon       byte = 6
+	wkbGeometryCollection byte = 7
+)
+
+func wkbTypeCode(t geom.GeometryType) byte {
+	switch t {
+	case geom.TypePoint:
+		return wkbPoint
+	case geom.TypeLineString:
+		return wkbLineString
+	case geom.TypePolygon:
+		return wkbPolygon
+	case geom.TypeMultiPoint:
+		return wkbMultiPoint
+	case geom.TypeMultiLineString:
+		return wkbMultiLineString
+	case geom.TypeMultiPolygon:
+		return wkbMultiPolygon
+	case geom.TypeGeometryCollection:
+		return wkbGeometryCollection
 	}
+	return wkbUnknown
+}
+
+// shapesToRegions returns the regions used for term indexing of decoded shapes,
+// matching the regions produced by geomToS2 for the original geometry.
+func shapesToRegions(shapes []s2.Shape) []s2.Region {
+	var regions []s2.Region
+	for _, s := range shapes {
+		switch v := s.(type) {
+		case *s2.PointVector:
+			for _, pt := range *v {
+				regions = append(regions, PointRegion{pt})
+			}
+		case *s2.Polyline:
+			regions = append(regions, v)
+		case *s2.Polygon:
+			regions = append(regions, v)
+		}
+	}
+	return regions
+}
+
+// shapesToGeomType reconstructs geometry of the given WKB type from a slice of shapes.
+// Single member multi geometries and multipolygons come back with their type,
+// collections get one member per shape. Z/M values are taken from zm, if any.
+func shapesToGeomType(shapes []s2.Shape, typ byte, zm *ordinates) geom.Geometry {
+	switch typ {
+	case wkbMultiPoint:
+		var pts []geom.Point
+		for _, s := range shapes {
+			if pv, ok := s.(*s2.PointVector); ok {
+				for _, pt := range *pv {
+					pts = append(pts, s2PointToGeom(pt, zm).MustAsPoint())
+				}
+			}
+		}
+		return geom.NewMultiPoint(pts).AsGeometry()
+	case wkbMultiLineString:
+		var lines []geom.LineString
+		for _, s := range shapes {
+			if pl, ok := s.(*s2.Polyline); ok {
+				lines = append(lines, s2PolylineToGeom(pl, zm).MustAsLineString())
+			}
+		}
+		return geom.NewMultiLineString(lines).AsGeometry()
+	case wkbPolygon, wkbMultiPolygon:
+		var polys []geom.Polygon
+		for _, s := range shapes {
+			if poly, ok := s.(*s2.Polygon); ok {
+				polys = append(polys, s2PolygonToPolygons(poly, zm)...)
+			}
+		}
+		if typ == wkbPolygon && len(polys) == 1 {
+			return polys[0].AsGeometry()
+		}
+		return geom.NewMultiPolygon(polys).AsGeometry()
+	case wkbGeometryCollection:
+		return shapesToCollection(shapes, zm)
+	}
+	return shapesToGeom(shapes, zm)
 }
 
 // shapesToGeom reconstructs geometry from a slice of shapes.
 // This is approximate as we lose the distinction between MultiPolygon and Polygon in S2,
 // but sufficient for returning results.
-func shapesToGeom(shapes []s2.Shape) geom.Geometry {
+func shapesToGeom(shapes []s2.Shape, zm *ordinates) geom.Geometry {
 	if len(shapes) == 0 {
 		return geom.Geometry{}
 	}
 
 	// Handle single shape cases common in simple features
 	if len(shapes) == 1 {
-		return shapeToGeom(shapes[0])
+		return shapeToGeom(shapes[0], zm)
 	}
 
-	// Multiple shapes imply MultiLineString (from our conversion logic above).
-	// Points are combined into one PointVector, Polygons into one S2Polygon.
-	// So multiple shapes here usually means multiple Polylines.
-	var lines []geom.LineString
-	for _, s := range shapes {
-		g := shapeToGeom(s)
-		if g.IsLineString() {
-			lines = append(lines, g.MustAsLineString())
+	// Multiple shapes of the same dimension come from a multi geometry:
+	// points are combined into one PointVector, polygons into one S2Polygon,
+	// so it is usually a MultiLineString. Mixed dimensions come from a GeometryCollection.
+	dim := shapes[0].Dimension()
+	for _, s := range shapes[1:] {
+		if s.Dimension() != dim {
+			return shapesToCollection(shapes, zm)
 		}
 	}
-	if len(lines) > 0 {
+
+	switch dim {
+	case 0:
+		var pts []geom.Point
+		for _, s := range shapes {
+			if pv, ok := s.(*s2.PointVector); ok {
+				for _, pt := range *pv {
+					pts = append(pts, s2PointToGeom(pt, zm).MustAsPoint())
+				}
+			}
+		}
+		return geom.NewMultiPoint(pts).AsGeometry()
+	case 1:
+		var lines []geom.LineString
+		for _, s := range shapes {
+			g := shapeToGeom(s, zm)
+			if g.IsLineString() {
+				lines = append(lines, g.MustAsLineString())
+			}
+		}
 		return geom.NewMultiLineString(lines).AsGeometry()
+	default:
+		var polys []geom.Polygon
+		for _, s := range shapes {
+			g := shapeToGeom(s, zm)
+			if g.IsPolygon() {
+				polys = append(polys, g.MustAsPolygon())
+			}
+		}
+		return geom.NewMultiPolygon(polys).AsGeometry()
 	}
+}
 
-	// Fallback return first
-	return shapeToGeom(shapes[0])
+func shapesToCollection(shapes []s2.Shape, zm *ordinates) geom.Geometry {
+	geoms := make([]geom.Geometry, 0, len(shapes))
+	for _, s := range shapes {
+		geoms = append(geoms, shapeToGeom(s, zm))
+	}
+	return geom.NewGeometryCollection(geoms).AsGeometry()
 }
 
-func shapeToGeom(s s2.Shape) geom.Geometry {
+func shapeToGeom(s s2.Shape, zm *ordinates) geom.Geometry {
 	switch v := s.(type) {
 	case *s2.PointVector:
 		if len(*v) == 1 {
-			return s2PointToGeom((*v)[0])
+			return s2PointToGeom((*v)[0], zm)
 		}
-		return s2MultiPointToGeom(v)
+		return s2MultiPointToGeom(v, zm)
 	case *s2.Polyline:
-		return s2PolylineToGeom(v)
+		return s2PolylineToGeom(v, zm)
 	case *s2.Polygon:
-		return s2PolygonToGeom(v)
+		return s2PolygonToGeom(v, zm)
 	default:
 		// Attempt fallback by dimension?
 		return geom.Geometry{}
 	}
 }
 
-func pointToS2(pt geom.Point) s2.Point {
+func pointToS2(pt geom.Point, v *validator, where string) (s2.Point, error) {
 	xy, _ := pt.XY()
-	return s2.PointFromLatLng(s2.LatLngFromDegrees(xy.Y, xy.X))
+	if err := v.point(xy.X, xy.Y, where); err != nil {
+		return s2.Point{}, err
+	}
+	return s2.PointFromLatLng(s2.LatLngFromDegrees(xy.Y, xy.X)), nil
 }
 
-func lineStringToS2(ls geom.LineString) *s2.Polyline {
-	seq := ls.Coordinates()
-	n := seq.Length()
-	pts := make([]s2.Point, n)
-	for i := 0; i < n; i++ {
-		xy := seq.GetXY(i)
-		pts[i] = s2.PointFromLatLng(s2.LatLngFromDegrees(xy.Y, xy.X))
+func lineStringToS2(ls geom.LineString, v *validator, where string) (*s2.Polyline, error) {
+	pts, err := sequenceToS2(ls.Coordinates(), v, where)
+	if err != nil {
+		return nil, err
+	}
+	if pts, err = v.polyline(pts, where); err != nil {
+		return nil, err
 	}
 	poly := s2.Polyline(pts)
-	return &poly
+	return &poly, nil
 }
 
-func polygonToS2(poly geom.Polygon) *s2.Polygon {
-	var loops []*s2.Loop
-	loops = append(loops, lineStringLoopToS2Loop(poly.ExteriorRing()))
-	n := poly.NumInteriorRings()
-	for i := 0; i < n; i++ {
-		loops = append(loops, lineStringLoopToS2Loop(poly.InteriorRingN(i)))
+func polygonToS2(poly geom.Polygon, v *validator) (*s2.Polygon, error) {
+	loops, err := polygonLoops(poly, v, "polygon")
+	if err != nil {
+		return nil, err
 	}
-	return s2.PolygonFromOrientedLoops(loops)
+	p := s2.PolygonFromOrientedLoops(loops)
+	if err := v.polygon(p, "polygon"); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func multiPolygonToS2(mp geom.MultiPolygon) *s2.Polygon {
+func multiPolygonToS2(mp geom.MultiPolygon, v *validator) (*s2.Polygon, error) {
 	var loops []*s2.Loop
 	n := mp.NumPolygons()
 	for i := 0; i < n; i++ {
-		poly := mp.PolygonN(i)
-		loops = append(loops, lineStringLoopToS2Loop(poly.ExteriorRing()))
-		numHoles := poly.NumInteriorRings()
-		for j := 0; j < numHoles; j++ {
-			loops = append(loops, lineStringLoopToS2Loop(poly.InteriorRingN(j)))
+		polyLoops, err := polygonLoops(mp.PolygonN(i), v, fmt.Sprintf("polygon %d", i))
+		if err != nil {
+			return nil, err
 		}
+		loops = append(loops, polyLoops...)
+	}
+	p := s2.PolygonFromOrientedLoops(loops)
+	if err := v.polygon(p, "multipolygon"); err != nil {
+		return nil, err
 	}
-	return s2.PolygonFromOrientedLoops(loops)
+	return p, nil
 }
 
-func lineStringLoopToS2Loop(ls geom.LineString) *s2.Loop {
+// polygonLoops converts the rings of a polygon, the exterior ring first.
+func polygonLoops(poly geom.Polygon, v *validator, where string) ([]*s2.Loop, error) {
+	shell, err := lineStringLoopToS2Loop(poly.ExteriorRing(), false, v, where+" exterior ring")
+	if err != nil {
+		return nil, err
+	}
+	loops := []*s2.Loop{shell}
+	n := poly.NumInteriorRings()
+	for i := 0; i < n; i++ {
+		hole, err := lineStringLoopToS2Loop(poly.InteriorRingN(i), true, v, fmt.Sprintf("%s interior ring %d", where, i))
+		if err != nil {
+			return nil, err
+		}
+		if hole != nil {
+			loops = append(loops, hole)
+		}
+	}
+	return loops, nil
+}
+
+func lineStringLoopToS2Loop(ls geom.LineString, hole bool, v *validator, where string) (*s2.Loop, error) {
 	seq := ls.Coordinates()
 	n := seq.Length()
 	if n > 0 {
@@ -171,59 +361,102 @@ func lineStringLoopToS2Loop(ls geom.LineString) *s2.Loop {
 			n--
 		}
 	}
+	pts, err := sequenceToS2(seq.Slice(0, n), v, where)
+	if err != nil {
+		return nil, err
+	}
+	return v.loop(pts, hole, where)
+}
+
+func sequenceToS2(seq geom.Sequence, v *validator, where string) ([]s2.Point, error) {
+	n := seq.Length()
 	pts := make([]s2.Point, n)
 	for i := 0; i < n; i++ {
 		xy := seq.GetXY(i)
+		if err := v.point(xy.X, xy.Y, where); err != nil {
+			return nil, err
+		}
 		pts[i] = s2.PointFromLatLng(s2.LatLngFromDegrees(xy.Y, xy.X))
 	}
-	return s2.LoopFromPoints(pts)
+	return pts, nil
 }
 
 // --- Inverse Helpers ---
 
-func s2PointToGeom(pt s2.Point) geom.Geometry {
-	ll := s2.LatLngFromPoint(pt)
-	return geom.NewPointXY(ll.Lng.Degrees(), ll.Lat.Degrees()).AsGeometry()
+func s2PointToGeom(pt s2.Point, zm *ordinates) geom.Geometry {
+	seq := geom.NewSequence(zm.appendVertex(nil, pt), zm.coordinatesType())
+	return geom.NewPoint(seq.Get(0)).AsGeometry()
 }
 
-func s2MultiPointToGeom(pv *s2.PointVector) geom.Geometry {
+func s2MultiPointToGeom(pv *s2.PointVector, zm *ordinates) geom.Geometry {
 	pts := make([]geom.Point, len(*pv))
 	for i, pt := range *pv {
-		ll := s2.LatLngFromPoint(pt)
-		pts[i] = geom.NewPointXY(ll.Lng.Degrees(), ll.Lat.Degrees())
+		pts[i] = s2PointToGeom(pt, zm).MustAsPoint()
 	}
 	return geom.NewMultiPoint(pts).AsGeometry()
 }
 
-func s2PolylineToGeom(pl *s2.Polyline) geom.Geometry {
-	coords := make([]float64, 0, len(*pl)*2)
+func s2PolylineToGeom(pl *s2.Polyline, zm *ordinates) geom.Geometry {
+	ctype := zm.coordinatesType()
+	coords := make([]float64, 0, len(*pl)*ctype.Dimension())
 	for _, pt := range *pl {
-		ll := s2.LatLngFromPoint(pt)
-		coords = append(coords, ll.Lng.Degrees(), ll.Lat.Degrees())
+		coords = zm.appendVertex(coords, pt)
 	}
-	seq := geom.NewSequence(coords, geom.DimXY)
+	seq := geom.NewSequence(coords, ctype)
 	return geom.NewLineString(seq).AsGeometry()
 }
 
-func s2PolygonToGeom(poly *s2.Polygon) geom.Geometry {
+func s2PolygonToGeom(poly *s2.Polygon, zm *ordinates) geom.Geometry {
+	polys := s2PolygonToPolygons(poly, zm)
+	switch len(polys) {
+	case 0:
+		return geom.NewPolygon(nil).AsGeometry()
+	case 1:
+		return polys[0].AsGeometry()
+	}
+	return geom.NewMultiPolygon(polys).AsGeometry()
+}
+
+// s2PolygonToPolygons splits a polygon into its shells, each with its holes.
+// Loops are in hierarchy order, a shell is followed by its descendants.
+// S2 keeps all loops counterclockwise, holes are reversed to be clockwise as in GeoJSON.
+func s2PolygonToPolygons(poly *s2.Polygon, zm *ordinates) []geom.Polygon {
+	var polys []geom.Polygon
 	var rings []geom.LineString
 	for i := 0; i < poly.NumLoops(); i++ {
 		loop := poly.Loop(i)
-		coords := make([]float64, 0, (loop.NumVertices()+1)*2)
-		vertices := loop.Vertices()
-		for _, v := range vertices {
-			ll := s2.LatLngFromPoint(v)
-			coords = append(coords, ll.Lng.Degrees(), ll.Lat.Degrees())
+		if !loop.IsHole() && len(rings) > 0 {
+			polys = append(polys, geom.NewPolygon(rings))
+			rings = nil
 		}
-		if len(vertices) > 0 {
-			ll := s2.LatLngFromPoint(vertices[0])
-			coords = append(coords, ll.Lng.Degrees(), ll.Lat.Degrees())
+		rings = append(rings, s2LoopToRing(loop, loop.IsHole(), zm))
+	}
+	if len(rings) > 0 {
+		polys = append(polys, geom.NewPolygon(rings))
+	}
+	return polys
+}
+
+func s2LoopToRing(loop *s2.Loop, reverse bool, zm *ordinates) geom.LineString {
+	ctype := zm.coordinatesType()
+	stride := ctype.Dimension()
+	vertices := loop.Vertices()
+
+	// Vertices are read in storage order to consume Z/M values in order
+	coords := make([]float64, 0, (len(vertices)+1)*stride)
+	for _, v := range vertices {
+		coords = zm.appendVertex(coords, v)
+	}
+	if reverse {
+		for i, j := 0, len(vertices)-1; i < j; i, j = i+1, j-1 {
+			for k := range stride {
+				coords[i*stride+k], coords[j*stride+k] = coords[j*stride+k], coords[i*stride+k]
+			}
 		}
-		seq := geom.NewSequence(coords, geom.DimXY)
-		rings = append(rings, geom.NewLineString(seq))
 	}
-	if len(rings) == 0 {
-		return geom.NewPolygon(nil).AsGeometry()
+	if len(vertices) > 0 {
+		// Close the ring
+		coords = append(coords, coords[:stride]...)
 	}
-	return geom.NewPolygon(rings).AsGeometry()
+	return geom.NewLineString(geom.NewSequence(coords, ctype))
 }
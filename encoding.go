@@ -4,19 +4,107 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc64"
 	"io"
+	"math"
+	"sync"
 
 	"github.com/golang/geo/s2"
+	geom "github.com/peterstace/simplefeatures/geom"
 )
 
 const (
 	typePointVector byte = 1
 	typePolyline    byte = 2
 	typePolygon     byte = 3
+
+	// Compact E7 encodings, see compact.go
+	typePointVectorE7 byte = 4
+	typePolylineE7    byte = 5
+	typePolygonE7     byte = 6
+)
+
+// blobMagic starts every versioned blob. Legacy blobs start with the uvarint length
+// of their JSON properties, which is never 0.
+var blobMagic = []byte{0x00, 'G', 'B'}
+
+const (
+	// blobVersionLegacy is the unversioned layout without header, compression or geometry info:
+	// [PropsLen][Props][ShapeCount][Shapes...][Index]
+	blobVersionLegacy byte = 0
+
+	// blobVersion1 adds the header and the geometry info. The stamp is the CRC-64 of
+	// the stored payload, it identifies the content of the blob without reading it:
+	// [Magic][Version][Compression][PropertyEncoding][Stamp]
+	// then, compressed, [PropsLen][Props][GeomInfo][ShapeCount][Shapes...][Index]
+	blobVersion1 byte = 1
+
+	// blobVersionCurrent is the version written by encodeFullEntry.
+	blobVersionCurrent = blobVersion1
 )
 
-// encodeFullEntry encodes properties and the spatial index (including shapes) into a blob.
-func encodeFullEntry(shapes []s2.Shape, props []byte) ([]byte, error) {
+// stampOffset is the offset of the stamp in a versioned blob.
+var stampOffset = len(blobMagic) + 3
+
+var stampTable = crc64.MakeTable(crc64.ECMA)
+
+// blobStamp returns the stamp of a blob from its header, ok is false for legacy
+// blobs.
+func blobStamp(data []byte) (stamp uint64, ok bool) {
+	if !bytes.HasPrefix(data, blobMagic) || len(data) < stampOffset+8 {
+		return 0, false
+	}
+	if data[len(blobMagic)] != blobVersion1 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(data[stampOffset:]), true
+}
+
+// blobHeader is the decoded header of a blob.
+type blobHeader struct {
+	version     byte
+	compression Compression
+	properties  PropertyEncoding // JSON for legacy blobs
+}
+
+// parseBlobHeader returns the header of a blob and its payload, decompressed.
+func parseBlobHeader(data []byte) (blobHeader, []byte, error) {
+	var h blobHeader
+	if !bytes.HasPrefix(data, blobMagic) {
+		return h, data, nil
+	}
+	data = data[len(blobMagic):]
+	if len(data) < 1 {
+		return h, nil, io.ErrUnexpectedEOF
+	}
+	h.version = data[0]
+	if h.version != blobVersion1 {
+		return h, nil, fmt.Errorf("unsupported blob version %d", h.version)
+	}
+	if len(data) < 3+8 {
+		return h, nil, io.ErrUnexpectedEOF
+	}
+	h.compression, h.properties = Compression(data[1]), PropertyEncoding(data[2])
+	data = data[3+8:]
+	payload, err := decompress(data, h.compression)
+	if err != nil {
+		return h, nil, fmt.Errorf("decompressing entry: %w", err)
+	}
+	return h, payload, nil
+}
+
+// geometryInfo describes the ingested geometry beyond what the S2 shapes retain.
+type geometryInfo struct {
+	typ byte       // WKB geometry type code of the ingested geometry
+	wkb []byte     // Original geometry as WKB, optional
+	zm  *ordinates // Z/M side channel, nil for XY geometries or when the WKB is kept
+}
+
+// encodeFullEntry encodes properties, geometry info and the spatial index (including shapes) into a blob,
+// using the shape encoding and compression of opts. The properties must already be encoded
+// with the property encoding of opts.
+// With EncodingE7 the shapes must already be snapped with snapShapes.
+func encodeFullEntry(shapes []s2.Shape, props []byte, info geometryInfo, opts Options) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// 1. Properties
@@ -26,7 +114,28 @@ func encodeFullEntry(shapes []s2.Shape, props []byte) ([]byte, error) {
 	buf.Write(b[:n])
 	buf.Write(props)
 
-	// 2. Shapes
+	// 2. Geometry Info
+	// [TypeByte][WKBLenUvarint][WKB]
+	buf.WriteByte(info.typ)
+	n = binary.PutUvarint(b[:], uint64(len(info.wkb)))
+	buf.Write(b[:n])
+	buf.Write(info.wkb)
+
+	// [CoordsTypeByte][NumValuesUvarint][Float64...]
+	// The values are only present for Z/M coordinates types
+	ctype := info.zm.coordinatesType()
+	buf.WriteByte(byte(ctype))
+	if ctype != geom.DimXY {
+		n = binary.PutUvarint(b[:], uint64(len(info.zm.values)))
+		buf.Write(b[:n])
+		for _, v := range info.zm.values {
+			var f [8]byte
+			binary.LittleEndian.PutUint64(f[:], math.Float64bits(v))
+			buf.Write(f[:])
+		}
+	}
+
+	// 3. Shapes
 	// [CountUvarint]
 	n = binary.PutUvarint(b[:], uint64(len(shapes)))
 	buf.Write(b[:n])
@@ -42,6 +151,18 @@ func encodeFullEntry(shapes []s2.Shape, props []byte) ([]byte, error) {
 		var shapeBuf bytes.Buffer
 		var typeByte byte
 
+		if opts.Encoding == EncodingE7 {
+			var err error
+			if typeByte, err = encodeE7Shape(&shapeBuf, shape); err != nil {
+				return nil, err
+			}
+			buf.WriteByte(typeByte)
+			n := binary.PutUvarint(b[:], uint64(shapeBuf.Len()))
+			buf.Write(b[:n])
+			buf.Write(shapeBuf.Bytes())
+			continue
+		}
+
 		switch s := shape.(type) {
 		case *s2.PointVector:
 			typeByte = typePointVector
@@ -75,20 +196,36 @@ func encodeFullEntry(shapes []s2.Shape, props []byte) ([]byte, error) {
 		buf.Write(shapeBuf.Bytes())
 	}
 
-	// 3. Index
+	// 4. Index
 	// Index must be built before encoding
 	index.Build()
 	if err := index.Encode(&buf); err != nil {
 		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	// Header
+	// [Magic][VersionByte][CompressionByte][PropertyEncodingByte][Stamp][Payload]
+	blob := make([]byte, 0, stampOffset+8+buf.Len())
+	blob = append(blob, blobMagic...)
+	blob = append(blob, blobVersionCurrent, byte(opts.Compression), byte(opts.PropertyEncoding))
+	blob = binary.LittleEndian.AppendUint64(blob, 0)
+	blob, err := compress(blob, buf.Bytes(), opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint64(blob[stampOffset:], crc64.Checksum(blob[stampOffset+8:], stampTable))
+	return blob, nil
 }
 
 // LazyShapeFactory implements s2.ShapeFactory to lazy load shapes from the buffer.
+// Shapes are decoded once, on first use, and it is safe for concurrent use. The
+// bounds of the shapes are checked against data by decodeFullEntry.
 type LazyShapeFactory struct {
-	r      *bytes.Reader
+	data   []byte
 	shapes []shapeInfo // offsets and types
+
+	mu      sync.Mutex
+	decoded []s2.Shape
 }
 
 type shapeInfo struct {
@@ -101,29 +238,31 @@ func (f *LazyShapeFactory) GetShape(id int) s2.Shape {
 	if id < 0 || id >= len(f.shapes) {
 		return nil
 	}
-	info := f.shapes[id]
 
-	// Seek and read
-	if _, err := f.r.Seek(info.offset, io.SeekStart); err != nil {
-		return nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.decoded == nil {
+		f.decoded = make([]s2.Shape, len(f.shapes))
+	}
+	if f.decoded[id] == nil {
+		info := f.shapes[id]
+		f.decoded[id] = decodeShape(bytes.NewReader(f.data[info.offset:info.offset+info.length]), info.typ)
 	}
-	// Limit reader
-	lr := io.LimitReader(f.r, info.length)
+	return f.decoded[id]
+}
 
-	switch info.typ {
+// decodeShape decodes a shape body, it returns nil on corrupted data.
+func decodeShape(r *bytes.Reader, typ byte) s2.Shape {
+	switch typ {
 	case typePointVector:
 		// Manual decode
-		count, err := binary.ReadUvarint(f.r) // consumes from reader, updates offset
+		count, err := binary.ReadUvarint(r)
 		if err != nil {
 			return nil
 		}
-		// binary.ReadUvarint is a byte reader, using f.r directly is risky if we wrap it.
-		// Re-make byte reader approach or just read carefully.
-		// Actually f.r is *bytes.Reader, which implements ByteReader.
-
 		pts := make([]s2.Point, count)
 		for i := 0; i < int(count); i++ {
-			if err := pts[i].Decode(f.r); err != nil {
+			if err := pts[i].Decode(r); err != nil {
 				return nil
 			}
 		}
@@ -131,16 +270,22 @@ func (f *LazyShapeFactory) GetShape(id int) s2.Shape {
 		return &pv
 	case typePolyline:
 		var p s2.Polyline
-		if err := p.Decode(lr); err != nil {
+		if err := p.Decode(r); err != nil {
 			return nil
 		}
 		return &p
 	case typePolygon:
 		var p s2.Polygon
-		if err := p.Decode(lr); err != nil {
+		if err := p.Decode(r); err != nil {
 			return nil
 		}
 		return &p
+	case typePointVectorE7, typePolylineE7, typePolygonE7:
+		shape, err := decodeE7Shape(r, typ)
+		if err != nil {
+			return nil
+		}
+		return shape
 	}
 	return nil
 }
@@ -149,37 +294,108 @@ func (f *LazyShapeFactory) Len() int {
 	return len(f.shapes)
 }
 
-// decodeFullEntry parses headers and returns properties, the lazy index, and the factory.
-func decodeFullEntry(data []byte) ([]byte, *s2.EncodedShapeIndex, *LazyShapeFactory, error) {
+// decodedEntry is a decoded object blob, its shapes are loaded lazily through the factory.
+type decodedEntry struct {
+	header  blobHeader
+	props   []byte // Encoded with header.properties
+	info    geometryInfo
+	index   *cellIndex
+	factory *LazyShapeFactory
+}
+
+// shapes loads all the shapes of the entry.
+func (e *decodedEntry) shapes() []s2.Shape {
+	shapes := make([]s2.Shape, e.factory.Len())
+	for i := range e.factory.Len() {
+		shapes[i] = e.factory.GetShape(i)
+	}
+	return shapes
+}
+
+// shapeEncoding returns the encoding the shapes of the entry were stored with.
+func (e *decodedEntry) shapeEncoding() ShapeEncoding {
+	for _, info := range e.factory.shapes {
+		if info.typ >= typePointVectorE7 {
+			return EncodingE7
+		}
+	}
+	return EncodingLossless
+}
+
+// geometry returns the ingested geometry, exact when the original WKB was kept,
+// otherwise rebuilt from the shapes following the recorded geometry type.
+func (e *decodedEntry) geometry(shapes []s2.Shape) (geom.Geometry, error) {
+	if len(e.info.wkb) > 0 {
+		return geom.UnmarshalWKB(e.info.wkb, geom.NoValidate{})
+	}
+	var zm *ordinates
+	if e.info.zm != nil {
+		// Fresh cursor, the geometry may be rebuilt more than once
+		zm = &ordinates{ctype: e.info.zm.ctype, values: e.info.zm.values}
+	}
+	return shapesToGeomType(shapes, e.info.typ, zm), nil
+}
+
+// decodeFullEntry parses headers and returns the entry with its lazy index.
+// Blobs of every version are supported, see parseBlobHeader.
+func decodeFullEntry(data []byte) (*decodedEntry, error) {
+	// Header
+	header, data, err := parseBlobHeader(data)
+	if err != nil {
+		return nil, err
+	}
 	r := bytes.NewReader(data)
 
 	// 1. Properties
 	propLen, err := binary.ReadUvarint(r)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
+	}
+	if propLen > uint64(r.Len()) {
+		return nil, fmt.Errorf("properties of %d bytes: %w", propLen, io.ErrUnexpectedEOF)
 	}
 	props := make([]byte, propLen)
 	if _, err := io.ReadFull(r, props); err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
-	// 2. Shapes Table Scan
+	// 2. Geometry Info
+	// Legacy blobs don't record it, their geometry is rebuilt from the shapes alone
+	var info geometryInfo
+	if header.version >= blobVersion1 {
+		if info, err = decodeGeometryInfo(r); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. Shapes Table Scan
 	shapeCount, err := binary.ReadUvarint(r)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
+	// Each shape takes at least a type and a length
+	if shapeCount > uint64(r.Len())/2 {
+		return nil, fmt.Errorf("%d shapes: %w", shapeCount, io.ErrUnexpectedEOF)
+	}
 	infos := make([]shapeInfo, shapeCount)
 	for i := 0; i < int(shapeCount); i++ {
 		typ, err := r.ReadByte()
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 		length, err := binary.ReadUvarint(r)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
+		}
+		// The factory slices the shapes out of data, their bounds are checked once here
+		if length > uint64(r.Len()) {
+			return nil, fmt.Errorf("shape %d of %d bytes: %w", i, length, io.ErrUnexpectedEOF)
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
 		}
-		offset, _ := r.Seek(0, io.SeekCurrent)
 
 		infos[i] = shapeInfo{
 			offset: offset,
@@ -189,23 +405,70 @@ func decodeFullEntry(data []byte) ([]byte, *s2.EncodedShapeIndex, *LazyShapeFact
 
 		// Skip body
 		if _, err := r.Seek(int64(length), io.SeekCurrent); err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 	}
 
-	// 3. Index
-	// Reader is now at start of Index
-	factory := &LazyShapeFactory{r: bytes.NewReader(data), shapes: infos}
+	// 4. Index
+	// Read in place, the cells are only decoded when a query reaches them.
+	// Seeking past the end doesn't fail, a truncated shape table ends after the data.
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if offset > int64(len(data)) {
+		return nil, fmt.Errorf("shape table ends at %d after the %d bytes of the entry: %w", offset, len(data), io.ErrUnexpectedEOF)
+	}
+	index, err := decodeCellIndex(data[offset:], len(infos))
+	if err != nil {
+		return nil, err
+	}
+	factory := &LazyShapeFactory{data: data, shapes: infos}
 
-	// Create a new reader from current position for the index init
-	// (EncodedShapeIndex.Init wraps in bufio if not ByteReader, bytes.Reader is fine)
-	// We need to pass the *remaining* part of stream or just the reader current pos?
-	// bytes.Reader tracks position.
+	return &decodedEntry{header: header, props: props, info: info, index: index, factory: factory}, nil
+}
 
-	index := s2.NewEncodedShapeIndex()
-	if err := index.Init(r, factory); err != nil {
-		return nil, nil, nil, fmt.Errorf("index init failed: %w", err)
+// decodeGeometryInfo reads the geometry info written by encodeFullEntry.
+func decodeGeometryInfo(r *bytes.Reader) (geometryInfo, error) {
+	var info geometryInfo
+	var err error
+	if info.typ, err = r.ReadByte(); err != nil {
+		return info, err
+	}
+	wkbLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return info, err
+	}
+	if wkbLen > uint64(r.Len()) {
+		return info, fmt.Errorf("WKB of %d bytes: %w", wkbLen, io.ErrUnexpectedEOF)
+	}
+	if wkbLen > 0 {
+		info.wkb = make([]byte, wkbLen)
+		if _, err := io.ReadFull(r, info.wkb); err != nil {
+			return info, err
+		}
 	}
 
-	return props, index, factory, nil
+	ctype, err := r.ReadByte()
+	if err != nil {
+		return info, err
+	}
+	if geom.CoordinatesType(ctype) != geom.DimXY {
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return info, err
+		}
+		if count > uint64(r.Len())/8 {
+			return info, fmt.Errorf("%d Z/M values: %w", count, io.ErrUnexpectedEOF)
+		}
+		raw := make([]byte, count*8)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return info, err
+		}
+		info.zm = &ordinates{ctype: geom.CoordinatesType(ctype), values: make([]float64, count)}
+		for i := range info.zm.values {
+			info.zm.values[i] = math.Float64frombits(binary.LittleEndian.Uint64(raw[i*8:]))
+		}
+	}
+	return info, nil
 }
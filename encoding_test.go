@@ -0,0 +1,41 @@
+package geostore
+
+import (
+	"testing"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// testBlobs returns uncompressed blobs of every shape type, keyed by name.
+func testBlobs(t *testing.T) map[string][]byte {
+	t.Helper()
+	store := newTestStore(t, DefaultOptions())
+	features := map[string]geom.GeoJSONFeature{
+		"point": {Geometry: geom.NewPointXY(-79.38, 43.65).AsGeometry(), Properties: map[string]any{"name": "p"}},
+		"line":  {Geometry: makeLineString([][]float64{{-79.4, 43.6}, {-79.3, 43.7}, {-79.2, 43.6}}).AsGeometry()},
+		"park":  parkFeature(),
+	}
+	blobs := make(map[string][]byte, len(features))
+	for name, f := range features {
+		entry, err := store.PrepareIndexEntry(name, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs[name] = entry.Blob
+	}
+	return blobs
+}
+
+// TestDecodeTruncated validates truncated blobs fail to decode instead of panicking
+func TestDecodeTruncated(t *testing.T) {
+	for name, blob := range testBlobs(t) {
+		if _, err := decodeFullEntry(blob); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for n := range len(blob) {
+			if _, err := decodeFullEntry(blob[:n]); err == nil {
+				t.Errorf("%s: decoded %d bytes out of %d", name, n, len(blob))
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package geostore
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Explain reports how a query ran, see QueryOptions.Explain.
+type Explain struct {
+	Layout IndexLayout
+
+	// Terms lists the index lookups in order, the query terms with IndexLayoutTerms,
+	// the cell ID ranges with the cell layouts, with the number of keys scanned.
+	Terms       []TermScan
+	KeysScanned int
+
+	InteriorCandidates int // Candidates matched through an interior covering
+	ExteriorCandidates int // Candidates matched through exterior coverings only
+
+	CulledByIndex    int // Candidates without a cell of their per-object index within the radius
+	CulledByDistance int // Candidates with cells within the radius but edges farther
+	Results          int
+
+	IndexTime  time.Duration // Looking up the candidates in the index
+	DecodeTime time.Duration // Decoding blobs, properties and geometries, summed over the query workers
+	RefineTime time.Duration // Computing distances, summed over the query workers
+	TotalTime  time.Duration
+}
+
+// TermScan is an index lookup of a query.
+type TermScan struct {
+	Term string
+	Keys int
+}
+
+// queryTrace collects the Explain of a query, nil when not explaining.
+// The refinement counters are updated concurrently by the query workers.
+type queryTrace struct {
+	explain *Explain
+
+	culledByIndex    atomic.Int64
+	culledByDistance atomic.Int64
+	decode           atomic.Int64 // Nanoseconds
+	refine           atomic.Int64 // Nanoseconds
+}
+
+// scan records an index lookup.
+func (t *queryTrace) scan(term string, keys int) {
+	t.explain.Terms = append(t.explain.Terms, TermScan{Term: term, Keys: keys})
+	t.explain.KeysScanned += keys
+}
+
+// now returns the current time, or the zero time when not explaining.
+func (t *queryTrace) now() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// decoded adds the time spent decoding since start.
+func (t *queryTrace) decoded(start time.Time) {
+	if t != nil {
+		t.decode.Add(int64(time.Since(start)))
+	}
+}
+
+// refined adds the time spent refining since start, and counts the culled candidates.
+func (t *queryTrace) refined(start time.Time, r refinement) {
+	if t == nil {
+		return
+	}
+	t.refine.Add(int64(time.Since(start)))
+	switch r {
+	case refineCulledByIndex:
+		t.culledByIndex.Add(1)
+	case refineCulledByDistance:
+		t.culledByDistance.Add(1)
+	}
+}
+
+// finish fills the explain once the query completed.
+func (t *queryTrace) finish(start time.Time, results int) {
+	e := t.explain
+	e.CulledByIndex = int(t.culledByIndex.Load())
+	e.CulledByDistance = int(t.culledByDistance.Load())
+	e.Results = results
+	e.DecodeTime = time.Duration(t.decode.Load())
+	e.RefineTime = time.Duration(t.refine.Load())
+	e.TotalTime = time.Since(start)
+}
@@ -0,0 +1,54 @@
+package geostore
+
+import "testing"
+
+// TestExplain validates the explain of queries accounts for every candidate
+func TestExplain(t *testing.T) {
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCompact} {
+		for _, workers := range []int{1, 4} {
+			opts := DefaultOptions()
+			opts.IndexLayout = l
+			opts.QueryWorkers = workers
+			store := newTestStore(t, opts)
+			writeGrid(t, store, 100, 16)
+
+			var explain Explain
+			results, err := store.FindClosestWithOptions(43.52, -79.45, 1500, QueryOptions{Explain: &explain})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 {
+				t.Fatal("expected results")
+			}
+
+			if explain.Layout != l || explain.Results != len(results) || len(explain.Terms) == 0 {
+				t.Errorf("%s/%d: unexpected explain %+v", l, workers, explain)
+			}
+			keys := 0
+			for _, term := range explain.Terms {
+				keys += term.Keys
+			}
+			if keys != explain.KeysScanned || keys < len(results) {
+				t.Errorf("%s/%d: %d keys scanned, terms sum to %d", l, workers, explain.KeysScanned, keys)
+			}
+			candidates := explain.InteriorCandidates + explain.ExteriorCandidates
+			if culled := explain.CulledByIndex + explain.CulledByDistance; candidates != explain.Results+culled {
+				t.Errorf("%s/%d: %d candidates, %d results and %d culled", l, workers, candidates, explain.Results, culled)
+			}
+			if explain.CulledByIndex+explain.CulledByDistance == 0 {
+				t.Errorf("%s/%d: expected culled candidates around the cap", l, workers)
+			}
+			if explain.DecodeTime <= 0 || explain.RefineTime <= 0 || explain.TotalTime < explain.IndexTime {
+				t.Errorf("%s/%d: unexpected timings %+v", l, workers, explain)
+			}
+
+			// Reused, the explain of the previous query is replaced
+			if _, err := store.FindClosestWithOptions(45, -75, 10, QueryOptions{Explain: &explain}); err != nil {
+				t.Fatal(err)
+			}
+			if explain.Results != 0 || explain.InteriorCandidates+explain.ExteriorCandidates != 0 {
+				t.Errorf("%s/%d: explain not reset %+v", l, workers, explain)
+			}
+		}
+	}
+}
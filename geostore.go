@@ -1,56 +1,147 @@
 package geostore
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"sort"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/golang/geo/s1"
 	"github.com/golang/geo/s2"
 	geom "github.com/peterstace/simplefeatures/geom"
 	bolt "go.etcd.io/bbolt"
 )
 
 const (
-	bucketObjects = "objects" // Value: [PropsLen][Props][ShapeCount][Shapes...][Index]
-	bucketIndex   = "index"   // Key: Term\x00ID
+	bucketObjects = "objects" // Value: [Magic][Version][Compression][PropsEncoding][PropsLen][Props][GeomInfo][ShapeCount][Shapes...][Index]
+	bucketIndex   = "index"   // Key: depends on the IndexLayout recorded in bucketMeta
+	bucketMeta    = "meta"    // Key: checkpoint:Name, Value: JSON Checkpoint; Key: layout, Value: IndexLayout
+	bucketIDs     = "ids"     // IndexLayoutCompact only, Key: Seq (big-endian), Value: ID
+	bucketSeqs    = "seqs"    // IndexLayoutCompact only, Key: ID, Value: Seq (big-endian)
 )
 
+// earthRadiusMeters converts angles on the sphere to distances.
+const earthRadiusMeters = 6371000.0
+
+var (
+	// ErrEmptyGeometry is returned when indexing a feature without geometry.
+	ErrEmptyGeometry = errors.New("geometry is empty")
+
+	// ErrUnsupportedGeometry is returned when indexing a geometry type the store can't convert.
+	ErrUnsupportedGeometry = errors.New("unsupported geometry type")
+
+	// ErrInvalidGeometry is returned when a geometry fails validation.
+	ErrInvalidGeometry = errors.New("invalid geometry")
+
+	// ErrNotFound is returned when no object is stored under an ID.
+	ErrNotFound = errors.New("not found")
+)
+
+// Options configures a GeoStore.
+type Options struct {
+	// Validation controls how invalid geometries are handled on ingest.
+	// DefaultOptions accepts geometries as they are, ValidationFix repairs the
+	// ring orientation and the duplicate vertices of untrusted inputs.
+	Validation ValidationPolicy
+
+	// KeepOriginalGeometry stores the ingested geometry as WKB next to the S2 shapes,
+	// results then return exactly what was imported instead of geometry rebuilt from S2.
+	KeepOriginalGeometry bool
+
+	// Encoding selects the shape encoding of new entries, entries of either
+	// encoding can be read whatever the setting.
+	Encoding ShapeEncoding
+
+	// Compression selects the compression of new entries, recorded per entry
+	// so a store can mix compressed and uncompressed entries.
+	Compression Compression
+
+	// PropertyEncoding selects the property encoding of new entries, recorded per entry.
+	PropertyEncoding PropertyEncoding
+
+	// QueryWorkers is the number of goroutines refining the candidates of a query
+	// concurrently, 0 or 1 refines them serially. Worth it for wide radii with
+	// many large candidates.
+	QueryWorkers int
+
+	// CacheSize is the number of decoded entries kept in memory between queries,
+	// 0 disables the cache. Worth it for large, frequently matched geometries.
+	CacheSize int
+
+	// IndexLayout selects the index layout of a new store. An existing store keeps
+	// the layout it was created with, use Reindex to change it.
+	IndexLayout IndexLayout
+
+	// Metrics, when set, receives measurements of the queries and writes.
+	Metrics Metrics
+
+	// Logger, when set, receives debug traces of the queries and writes, and
+	// warnings about the entries skipped by queries.
+	Logger *slog.Logger
+
+	// ReadOnly opens an existing store with a shared lock, so it can be read while
+	// other readers have it open. Nothing is written, writes return an error.
+	ReadOnly bool
+}
+
+// DefaultOptions returns the options used by NewGeoStore.
+func DefaultOptions() Options {
+	return Options{
+		Validation: ValidationAccept,
+	}
+}
+
 type GeoStore struct {
 	db      *bolt.DB
 	indexer *s2.RegionTermIndexer
+	opts    Options
+	cache   *entryCache   // nil when disabled
+	layout  atomic.Uint32 // IndexLayout, as recorded in bucketMeta
 }
 
 type StoredItem struct {
-	ID         string
-	Geometry   geom.Geometry
-	Properties map[string]any
-	Distance   float64
+	ID            string
+	Geometry      geom.Geometry
+	Properties    map[string]any
+	RawProperties json.RawMessage // Set instead of Properties with QueryOptions.RawProperties
+	Distance      float64
+}
+
+// Checkpoint records the progress of a bulk import.
+// It is committed in the same transaction as a batch, so it never runs ahead of the written data.
+type Checkpoint struct {
+	Offset      int64  // Input byte offset right after the last handled feature
+	Ordinal     int64  // Number of features handled, committed or rejected
+	Fingerprint string // Caller defined identity of the input, to refuse resuming on another file
+
+	// Handled are the features past Ordinal already handled, by ordinal with the
+	// input offset right after them: features may finish out of order.
+	Handled map[int64]int64
 }
 
 type IndexEntry struct {
 	ID            string
 	Blob          []byte
-	InteriorTerms []string // Cells completely inside the polygon (guaranteed match)
-	ExteriorTerms []string // Cells intersecting the polygon boundary (need PIP test)
+	InteriorTerms []string    // Cells completely inside the polygon (guaranteed match), IndexLayoutTerms
+	ExteriorTerms []string    // Cells intersecting the polygon boundary (need PIP test), IndexLayoutTerms
+	InteriorCells []s2.CellID // Interior covering, IndexLayoutCells
+	ExteriorCells []s2.CellID // Exterior covering, IndexLayoutCells
+	Repairs       []string    // Repairs applied to the geometry by the validation policy
+
+	layout IndexLayout // Layout the terms or cells were generated for
 }
 
+// NewGeoStore opens or creates the store at dbPath with DefaultOptions.
 func NewGeoStore(dbPath string) (*GeoStore, error) {
-	db, err := bolt.Open(dbPath, 0600, nil)
-	if err != nil {
-		return nil, err
-	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists([]byte(bucketObjects)); err != nil {
-			return err
-		}
-		if _, err := tx.CreateBucketIfNotExists([]byte(bucketIndex)); err != nil {
-			return err
-		}
-		return nil
-	})
+	return NewGeoStoreWithOptions(dbPath, DefaultOptions())
+}
+
+// NewGeoStoreWithOptions opens or creates the store at dbPath. With
+// Options.ReadOnly, the store must exist.
+func NewGeoStoreWithOptions(dbPath string, options Options) (*GeoStore, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{ReadOnly: options.ReadOnly})
 	if err != nil {
 		return nil, err
 	}
@@ -60,7 +151,55 @@ func NewGeoStore(dbPath string) (*GeoStore, error) {
 	opts.MaxLevel = 16
 	opts.MaxCells = 8
 
-	return &GeoStore{db: db, indexer: s2.NewRegionTermIndexer(opts)}, nil
+	gs := &GeoStore{db: db, indexer: s2.NewRegionTermIndexer(opts), opts: options}
+	if gs.opts.Metrics == nil {
+		gs.opts.Metrics = nopMetrics{}
+	}
+	if gs.opts.Logger == nil {
+		gs.opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	if _, err := gs.indexLayout(options.IndexLayout); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if options.ReadOnly {
+		err = db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket([]byte(bucketObjects)) == nil || tx.Bucket([]byte(bucketIndex)) == nil {
+				return fmt.Errorf("%s is not a store", dbPath)
+			}
+			return gs.loadLayout(tx)
+		})
+	} else {
+		err = db.Update(func(tx *bolt.Tx) error {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucketObjects)); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucketIndex)); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucketMeta)); err != nil {
+				return err
+			}
+			if err := initLayout(tx, options.IndexLayout); err != nil {
+				return err
+			}
+			return gs.loadLayout(tx)
+		})
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if options.CacheSize > 0 {
+		if gs.cache, err = newEntryCache(options.CacheSize); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	gs.opts.Logger.Debug("store opened", "path", dbPath, "layout", gs.IndexLayout().String())
+	return gs, nil
 }
 
 func (gs *GeoStore) Close() error {
@@ -71,39 +210,59 @@ func (gs *GeoStore) Close() error {
 // It accepts a parsed geom.GeoJSONFeature to avoid double unmarshalling.
 func (gs *GeoStore) PrepareIndexEntry(id string, feature geom.GeoJSONFeature) (IndexEntry, error) {
 	if feature.Geometry.IsEmpty() {
-		return IndexEntry{}, errors.New("geometry is empty")
+		return IndexEntry{}, ErrEmptyGeometry
 	}
 
 	// Convert to S2 Shapes
-	shapes, regions, err := geomToS2(feature.Geometry)
+	v := &validator{policy: gs.opts.Validation}
+	shapes, regions, err := geomToS2(feature.Geometry, v)
 	if err != nil {
 		return IndexEntry{}, err
 	}
+	snap := func(p s2.Point) s2.Point { return p }
+	if gs.opts.Encoding == EncodingE7 {
+		// Index and cover the snapped shapes, as they will be decoded
+		shapes = snapShapes(shapes)
+		regions = shapesToRegions(shapes)
+		snap = snapE7
+	}
 
 	// Encode Props
-	propsJSON, err := json.Marshal(feature.Properties)
+	props, err := marshalProperties(feature.Properties, gs.opts.PropertyEncoding)
 	if err != nil {
 		return IndexEntry{}, err
 	}
 
-	// Encode Full Binary Blob (Props + Shapes + Index)
-	blob, err := encodeFullEntry(shapes, propsJSON)
+	info := geometryInfo{typ: wkbTypeCode(feature.Geometry.Type())}
+	if gs.opts.KeepOriginalGeometry {
+		info.wkb = feature.Geometry.AsBinary()
+	} else {
+		info.zm = collectOrdinates(feature.Geometry, shapes, snap)
+	}
+
+	// Encode Full Binary Blob (Props + Geometry Info + Shapes + Index)
+	blob, err := encodeFullEntry(shapes, props, info, gs.opts)
 	if err != nil {
 		return IndexEntry{}, err
 	}
 
-	// Generate Interior and Exterior Covers
-	// Interior cover: cells completely inside the polygon (if interior cell matches, polygon is definitely inside)
-	// Exterior cover: cells intersecting the polygon (if only exterior matches, need point-in-polygon test)
+	entry := IndexEntry{ID: id, Blob: blob, Repairs: v.repairs, layout: gs.IndexLayout()}
+	layout, err := gs.indexLayout(entry.layout)
+	if err != nil {
+		return IndexEntry{}, err
+	}
+	layout.cover(&entry, regions)
+	return entry, nil
+}
+
+// coverTerms generates the interior and exterior index terms for regions.
+// Interior cover: cells completely inside the polygon (if interior cell matches, polygon is definitely inside)
+// Exterior cover: cells intersecting the polygon (if only exterior matches, need point-in-polygon test)
+func (gs *GeoStore) coverTerms(regions []s2.Region) ([]string, []string) {
 	interiorTermSet := make(map[string]struct{})
 	exteriorTermSet := make(map[string]struct{})
 
-	// Create a RegionCoverer with same options as the indexer
-	rc := &s2.RegionCoverer{
-		MinLevel: gs.indexer.Options.MinLevel,
-		MaxLevel: gs.indexer.Options.MaxLevel,
-		MaxCells: gs.indexer.Options.MaxCells,
-	}
+	rc := gs.coverer()
 
 	for _, reg := range regions {
 		// Exterior cover: cells that intersect the region
@@ -131,60 +290,180 @@ func (gs *GeoStore) PrepareIndexEntry(id string, feature geom.GeoJSONFeature) (I
 	for t := range exteriorTermSet {
 		exteriorTerms = append(exteriorTerms, t)
 	}
+	return interiorTerms, exteriorTerms
+}
 
-	return IndexEntry{
-		ID:            id,
-		Blob:          blob,
-		InteriorTerms: interiorTerms,
-		ExteriorTerms: exteriorTerms,
-	}, nil
+// indexKey builds an index bucket key: Prefix + Term + \x00 + ID.
+func indexKey(prefix, term, id string) []byte {
+	key := make([]byte, len(prefix)+len(term)+1+len(id))
+	n := copy(key, prefix)
+	n += copy(key[n:], term)
+	key[n] = 0
+	copy(key[n+1:], id)
+	return key
 }
 
 func (gs *GeoStore) WriteBatch(entries []IndexEntry) error {
-	return gs.db.Update(func(tx *bolt.Tx) error {
-		bObj := tx.Bucket([]byte(bucketObjects))
-		bIdx := tx.Bucket([]byte(bucketIndex))
+	start := time.Now()
+	err := gs.db.Update(func(tx *bolt.Tx) error {
+		return gs.writeEntries(tx, entries)
+	})
+	gs.observeWrite(entries, start, err)
+	return err
+}
 
-		for _, entry := range entries {
-			if err := bObj.Put([]byte(entry.ID), entry.Blob); err != nil {
-				return err
-			}
+// WriteBatchCheckpoint writes entries and records cp under name in a single transaction.
+func (gs *GeoStore) WriteBatchCheckpoint(entries []IndexEntry, name string, cp Checkpoint) error {
+	value, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = gs.db.Update(func(tx *bolt.Tx) error {
+		if err := gs.writeEntries(tx, entries); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(bucketMeta)).Put([]byte("checkpoint:"+name), value)
+	})
+	gs.observeWrite(entries, start, err)
+	return err
+}
+
+// observeWrite reports a batch write started at start, and invalidates its entries.
+func (gs *GeoStore) observeWrite(entries []IndexEntry, start time.Time, err error) {
+	d := time.Since(start)
+	gs.opts.Metrics.ObserveWrite(len(entries), d, err)
+	if err != nil {
+		gs.opts.Logger.Debug("batch write failed", "entries", len(entries), "duration", d, "error", err)
+	} else {
+		gs.opts.Logger.Debug("batch written", "entries", len(entries), "duration", d)
+	}
+	gs.invalidate(entries)
+}
 
-			// Store interior terms with "int:" prefix
-			// Interior terms guarantee the polygon contains the query point
-			for _, term := range entry.InteriorTerms {
-				prefixedTerm := "int:" + term
-				key := make([]byte, len(prefixedTerm)+1+len(entry.ID))
-				copy(key, prefixedTerm)
-				key[len(prefixedTerm)] = 0
-				copy(key[len(prefixedTerm)+1:], entry.ID)
+// invalidate drops the overwritten entries from the cache to free memory early,
+// cached entries are checked against the stored blob anyway.
+func (gs *GeoStore) invalidate(entries []IndexEntry) {
+	if gs.cache != nil {
+		gs.cache.remove(entries)
+	}
+}
 
-				if err := bIdx.Put(key, []byte("1")); err != nil {
-					return err
-				}
-			}
+// Checkpoint returns the checkpoint recorded under name, ok is false if there is none.
+func (gs *GeoStore) Checkpoint(name string) (cp Checkpoint, ok bool, err error) {
+	err = gs.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(bucketMeta))
+		if meta == nil { // Opened read only before the meta bucket was created
+			return nil
+		}
+		value := meta.Get([]byte("checkpoint:" + name))
+		if value == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(value, &cp)
+	})
+	return cp, ok, err
+}
 
-			// Store exterior terms with "ext:" prefix
-			// Exterior terms require point-in-polygon test for confirmation
-			for _, term := range entry.ExteriorTerms {
-				prefixedTerm := "ext:" + term
-				key := make([]byte, len(prefixedTerm)+1+len(entry.ID))
-				copy(key, prefixedTerm)
-				key[len(prefixedTerm)] = 0
-				copy(key[len(prefixedTerm)+1:], entry.ID)
+func (gs *GeoStore) writeEntries(tx *bolt.Tx, entries []IndexEntry) error {
+	bObj := tx.Bucket([]byte(bucketObjects))
+	bIdx := tx.Bucket([]byte(bucketIndex))
+	l, layout, err := gs.txLayout(tx)
+	if err != nil {
+		return err
+	}
 
-				if err := bIdx.Put(key, []byte("1")); err != nil {
-					return err
-				}
+	for _, entry := range entries {
+		// Overwriting an existing object: drop its previous keys first,
+		// otherwise they would keep matching the old geometry.
+		if err := gs.removeTerms(tx, layout, entry.ID); err != nil {
+			return err
+		}
+
+		if err := bObj.Put([]byte(entry.ID), entry.Blob); err != nil {
+			return err
+		}
+
+		// Prepared before a Reindex to another layout, covered again from the blob
+		if entry.layout != l {
+			decoded, err := decodeFullEntry(entry.Blob)
+			if err != nil {
+				return fmt.Errorf("entry %s: %w", entry.ID, err)
 			}
+			entry = IndexEntry{ID: entry.ID, layout: l}
+			layout.cover(&entry, shapesToRegions(decoded.shapes()))
 		}
+
+		// Interior keys guarantee the polygon contains the query point,
+		// exterior keys require a point-in-polygon test for confirmation
+		keys, err := layout.keys(tx, entry, true)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := bIdx.Put(key, layout.value()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeTerms deletes the index keys of the object currently stored under id.
+// Keys are not stored alongside the object, they are regenerated from its shapes,
+// or found by scanning the index when the stored blob can't be decoded, so a
+// corrupted object can still be overwritten or deleted.
+// The internal identifiers of the object are kept, an overwrite reuses them.
+func (gs *GeoStore) removeTerms(tx *bolt.Tx, layout indexLayout, id string) error {
+	data := tx.Bucket([]byte(bucketObjects)).Get([]byte(id))
+	if data == nil {
 		return nil
+	}
+
+	var keys [][]byte
+	decoded, err := decodeFullEntry(data)
+	if err == nil {
+		entry := IndexEntry{ID: id}
+		layout.cover(&entry, shapesToRegions(decoded.shapes()))
+		keys, err = layout.keys(tx, entry, false)
+	} else {
+		gs.opts.Logger.Warn("scanning the index for the keys of an undecodable entry", "id", id, "error", err)
+		keys, err = layout.scanKeys(tx, id)
+	}
+	if err != nil {
+		return err
+	}
+	bIdx := tx.Bucket([]byte(bucketIndex))
+	for _, key := range keys {
+		if err := bIdx.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Has reports whether an object is stored under id.
+func (gs *GeoStore) Has(id string) (found bool, err error) {
+	err = gs.View(func(snap *Snapshot) error {
+		found, err = snap.Has(id)
+		return err
+	})
+	return found, err
+}
+
+// Get returns the object stored under id, with its geometry, or ErrNotFound.
+func (gs *GeoStore) Get(id string) (item StoredItem, err error) {
+	err = gs.View(func(snap *Snapshot) error {
+		item, err = snap.Get(id)
+		return err
 	})
+	return item, err
 }
 
 func (gs *GeoStore) Put(id string, geoJSON []byte) error {
-	var feature geom.GeoJSONFeature
-	if err := json.Unmarshal(geoJSON, &feature); err != nil {
+	feature, err := ParseFeature(geoJSON)
+	if err != nil {
 		return fmt.Errorf("invalid geojson: %w", err)
 	}
 	entry, err := gs.PrepareIndexEntry(id, feature)
@@ -194,191 +473,232 @@ func (gs *GeoStore) Put(id string, geoJSON []byte) error {
 	return gs.WriteBatch([]IndexEntry{entry})
 }
 
-func (gs *GeoStore) FindClosest(lat, lng float64, radiusMeters float64, withGeometry bool) ([]StoredItem, error) {
-	center := s2.PointFromLatLng(s2.LatLngFromDegrees(lat, lng))
-	earthRadiusMeters := 6371000.0
-	angleRadius := s1.Angle(radiusMeters / earthRadiusMeters)
-	capRegion := s2.CapFromCenterAngle(center, angleRadius)
-	queryTerms := gs.indexer.GetQueryTerms(capRegion, "")
-
-	// Two-pass approach with interior/exterior optimization:
-	// 1. Interior candidates: matched via interior cover terms (guaranteed to be inside polygon)
-	// 2. Exterior candidates: matched only via exterior cover terms (need point-in-polygon test)
-	interiorCandidates := make(map[string]struct{})
-	exteriorCandidates := make(map[string]struct{})
-
-	err := gs.db.View(func(tx *bolt.Tx) error {
-		bIdx := tx.Bucket([]byte(bucketIndex))
-		c := bIdx.Cursor()
-
-		// First pass: query interior terms (guaranteed matches for polygons)
-		for _, term := range queryTerms {
-			interiorPrefix := []byte("int:" + term + "\x00")
-			for k, _ := c.Seek(interiorPrefix); k != nil && bytes.HasPrefix(k, interiorPrefix); k, _ = c.Next() {
-				idBytes := bytes.TrimPrefix(k, interiorPrefix)
-				interiorCandidates[string(idBytes)] = struct{}{}
-			}
+// Delete removes the object stored under id with its index keys, or returns ErrNotFound.
+func (gs *GeoStore) Delete(id string) error {
+	start := time.Now()
+	err := gs.db.Update(func(tx *bolt.Tx) error {
+		bObj := tx.Bucket([]byte(bucketObjects))
+		if bObj.Get([]byte(id)) == nil {
+			return ErrNotFound
 		}
-
-		// Second pass: query exterior terms
-		// Only add to exteriorCandidates if not already in interiorCandidates
-		for _, term := range queryTerms {
-			exteriorPrefix := []byte("ext:" + term + "\x00")
-			for k, _ := c.Seek(exteriorPrefix); k != nil && bytes.HasPrefix(k, exteriorPrefix); k, _ = c.Next() {
-				idBytes := bytes.TrimPrefix(k, exteriorPrefix)
-				id := string(idBytes)
-				if _, isInterior := interiorCandidates[id]; !isInterior {
-					exteriorCandidates[id] = struct{}{}
-				}
-			}
+		_, layout, err := gs.txLayout(tx)
+		if err != nil {
+			return err
 		}
-		return nil
+		if err := gs.removeTerms(tx, layout, id); err != nil {
+			return err
+		}
+		if err := removeObjectSeq(tx, id); err != nil {
+			return err
+		}
+		return bObj.Delete([]byte(id))
 	})
-	if err != nil {
-		return nil, err
+	gs.opts.Metrics.ObserveDelete(time.Since(start), err)
+	if err == nil {
+		gs.invalidate([]IndexEntry{{ID: id}})
+		gs.opts.Logger.Debug("deleted", "id", id)
 	}
+	return err
+}
 
-	var results []StoredItem
+func (gs *GeoStore) FindClosest(lat, lng float64, radiusMeters float64, withGeometry bool) ([]StoredItem, error) {
+	return gs.FindClosestWithOptions(lat, lng, radiusMeters, QueryOptions{WithGeometry: withGeometry})
+}
 
-	err = gs.db.View(func(tx *bolt.Tx) error {
-		bObj := tx.Bucket([]byte(bucketObjects))
+// FindClosestWithOptions is FindClosest with control over the geometry and properties returned.
+func (gs *GeoStore) FindClosestWithOptions(lat, lng float64, radiusMeters float64, opts QueryOptions) (results []StoredItem, err error) {
+	err = gs.View(func(snap *Snapshot) error {
+		results, err = snap.FindClosestWithOptions(lat, lng, radiusMeters, opts)
+		return err
+	})
+	return results, err
+}
 
-		// Process interior candidates first (no PIP test needed)
-		for id := range interiorCandidates {
-			item, err := gs.processCandidate(id, center, angleRadius, withGeometry, bObj, true)
-			if err != nil {
-				continue
+// EachClosest calls fn with the objects within radiusMeters of lat/lng as they are
+// refined, see Snapshot.EachClosest.
+func (gs *GeoStore) EachClosest(lat, lng float64, radiusMeters float64, opts QueryOptions, fn func(StoredItem) error) error {
+	return gs.View(func(snap *Snapshot) error {
+		return snap.EachClosest(lat, lng, radiusMeters, opts, fn)
+	})
+}
+
+// FindContaining returns the objects covering lat/lng: the polygons containing
+// it, and the lines and points passing exactly through it.
+func (gs *GeoStore) FindContaining(lat, lng float64, opts QueryOptions) (results []StoredItem, err error) {
+	err = gs.View(func(snap *Snapshot) error {
+		results, err = snap.FindContaining(lat, lng, opts)
+		return err
+	})
+	return results, err
+}
+
+// FindInRect returns the objects intersecting a rectangle, see Snapshot.FindInRect.
+func (gs *GeoStore) FindInRect(minLat, minLng, maxLat, maxLng float64, opts QueryOptions) (results []StoredItem, err error) {
+	err = gs.View(func(snap *Snapshot) error {
+		results, err = snap.FindInRect(minLat, minLng, maxLat, maxLng, opts)
+		return err
+	})
+	return results, err
+}
+
+// refineCandidates runs processCandidate over the candidates, concurrently with
+// Options.QueryWorkers, and calls emit with each match as it is refined. Calls to
+// emit are serialized, an error from emit stops the query and is returned.
+// Candidates failing to decode are skipped, a PropertiesError or the end of the
+// query context fails the query. Blobs are read from bObj by the calling goroutine,
+// workers only decode and refine them, all of them before the transaction ends.
+func (gs *GeoStore) refineCandidates(bObj *bolt.Bucket, candidates []string, refine refineFunc, opts QueryOptions, emit func(StoredItem) error) error {
+	workers := min(gs.opts.QueryWorkers, len(candidates))
+	if workers <= 1 {
+		for _, id := range candidates {
+			if err := opts.done(); err != nil {
+				return err
 			}
-			if item != nil {
-				results = append(results, *item)
+			data := bObj.Get([]byte(id))
+			if data == nil {
+				continue
 			}
-		}
-
-		// Process exterior candidates (need full distance check)
-		for id := range exteriorCandidates {
-			item, err := gs.processCandidate(id, center, angleRadius, withGeometry, bObj, false)
+			item, err := gs.processCandidate(id, data, refine, opts)
 			if err != nil {
+				if err := gs.candidateError(id, err); err != nil {
+					return err
+				}
 				continue
 			}
 			if item != nil {
-				results = append(results, *item)
+				if err := emit(*item); err != nil {
+					return err
+				}
 			}
 		}
 		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
 
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Distance < results[j].Distance
-	})
-
-	return results, nil
+	type job struct {
+		id   string
+		data []byte
+	}
+	jobs := make(chan job, workers)
+	// mu serializes emit and guards failed, the first error stopping the query
+	var mu sync.Mutex
+	var failed error
+	fail := func(err error) {
+		if failed == nil {
+			failed = err
+		}
+	}
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				item, err := gs.processCandidate(j.id, j.data, refine, opts)
+				if err != nil {
+					if err := gs.candidateError(j.id, err); err != nil {
+						mu.Lock()
+						fail(err)
+						mu.Unlock()
+					}
+					continue
+				}
+				if item == nil {
+					continue
+				}
+				mu.Lock()
+				if failed == nil {
+					if err := emit(*item); err != nil {
+						fail(err)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range candidates {
+		mu.Lock()
+		if err := opts.done(); err != nil {
+			fail(err)
+		}
+		stop := failed != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		if data := bObj.Get([]byte(id)); data != nil {
+			jobs <- job{id: id, data: data}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return failed
 }
 
-// processCandidate processes a single candidate and returns a StoredItem if it matches
-// isInteriorMatch: if true, the candidate matched an interior cell (guaranteed inside for polygons)
-func (gs *GeoStore) processCandidate(id string, center s2.Point, angleRadius s1.Angle, withGeometry bool, bObj *bolt.Bucket, isInteriorMatch bool) (*StoredItem, error) {
-	data := bObj.Get([]byte(id))
-	if data == nil {
-		return nil, fmt.Errorf("data not found for id: %s", id)
+// candidateError handles a candidate failing to process: a PropertiesError is
+// returned to fail the query, other errors are reported and the candidate skipped.
+func (gs *GeoStore) candidateError(id string, err error) error {
+	var perr *PropertiesError
+	if errors.As(err, &perr) {
+		return err
 	}
+	gs.decodeError(id, err)
+	return nil
+}
+
+// decodeError reports a candidate skipped by a query because it failed to decode.
+func (gs *GeoStore) decodeError(id string, err error) {
+	gs.opts.Metrics.DecodeError()
+	gs.opts.Logger.Warn("skipping undecodable entry", "id", id, "error", err)
+}
 
-	propsJSON, lazyIndex, factory, err := decodeFullEntry(data)
+// processCandidate processes a single candidate blob and returns a StoredItem if it matches.
+// It is safe for concurrent use.
+func (gs *GeoStore) processCandidate(id string, data []byte, refine refineFunc, opts QueryOptions) (*StoredItem, error) {
+	start := opts.trace.now()
+	entry, err := gs.decodeEntry(id, data)
+	opts.trace.decoded(start)
 	if err != nil {
 		return nil, err
 	}
 
-	limit := s1.ChordAngleFromAngle(angleRadius)
-
-	// Fast cull using index iterator
-	iter := lazyIndex.Iterator()
-	match := false
-
-	// Scan index cells
-	for iter.Begin(); !iter.Done(); iter.Next() {
-		cellID := iter.CellID()
-		cell := s2.CellFromCellID(cellID)
-
-		// Conservative distance to cell
-		if cell.Distance(center) > limit {
-			continue
-		}
-
-		// Check for shapes in this cell (if any)
-		idxCell := iter.IndexCell()
-		if idxCell.NumClipped() > 0 {
-			match = true
-			break
-		}
+	// Refine with the per-object index, only the shapes near the query are loaded
+	start = opts.trace.now()
+	dist, r, err := refine(entry)
+	if err != nil {
+		return nil, err
 	}
-
-	if !match {
+	opts.trace.refined(start, r)
+	if r != refineMatch {
 		return nil, nil
 	}
 
-	// Load shapes from factory for precise check
-	shapes := make([]s2.Shape, factory.Len())
-	for i := range factory.Len() {
-		shapes[i] = factory.GetShape(i)
-	}
-
-	// For interior matches on polygons, we can optimize by checking if center is inside
-	// This avoids the expensive edge-by-edge distance calculation
-	var minDistAngle s1.Angle
-	if isInteriorMatch && len(shapes) > 0 {
-		// Check if this is a polygon shape
-		switch s := shapes[0].(type) {
-		case *s2.Polygon:
-			// For interior matches, we know the query point is in an interior cell
-			// But we still need to verify it's within the radius
-			if s.ContainsPoint(center) {
-				minDistAngle = 0 // Inside polygon, distance is 0
-			} else {
-				// Fallback to edge distance
-				minDistAngle = gs.calculateMinDistance(center, shapes)
-			}
-		default:
-			// For non-polygon shapes, calculate normal distance
-			minDistAngle = gs.calculateMinDistance(center, shapes)
-		}
+	// Properties are only decoded once the candidate matched
+	start = opts.trace.now()
+	defer opts.trace.decoded(start)
+
+	var props map[string]any
+	var rawProps json.RawMessage
+	if opts.decodeInto != nil {
+		err = opts.decodeInto(id, entry.props, entry.header.properties)
 	} else {
-		// For exterior matches or non-polygons, do full distance calculation
-		minDistAngle = gs.calculateMinDistance(center, shapes)
+		props, rawProps, err = decodeProperties(entry.props, entry.header.properties, opts)
 	}
-
-	if minDistAngle <= angleRadius {
-		var props map[string]any
-		_ = json.Unmarshal(propsJSON, &props)
-
-		var geo geom.Geometry
-		if withGeometry {
-			geo = shapesToGeom(shapes)
-		}
-
-		return &StoredItem{
-			ID:         id,
-			Geometry:   geo,
-			Properties: props,
-			Distance:   float64(minDistAngle) * 6371000.0,
-		}, nil
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, nil
-}
-
-// calculateMinDistance computes the minimum distance from center to any edge in shapes
-func (gs *GeoStore) calculateMinDistance(center s2.Point, shapes []s2.Shape) s1.Angle {
-	minDistAngle := s1.InfAngle()
-	for _, s := range shapes {
-		for i := range s.NumEdges() {
-			e := s.Edge(i)
-			d := s2.DistanceFromSegment(center, e.V0, e.V1)
-			if d < minDistAngle {
-				minDistAngle = d
-			}
+	var geo geom.Geometry
+	if opts.WithGeometry {
+		if geo, err = entry.geometry(entry.shapes()); err != nil {
+			return nil, err
 		}
 	}
-	return minDistAngle
+
+	return &StoredItem{
+		ID:            id,
+		Geometry:      geo,
+		Properties:    props,
+		RawProperties: rawProps,
+		Distance:      float64(dist.Angle()) * earthRadiusMeters,
+	}, nil
 }
@@ -2,12 +2,18 @@ package geostore
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"testing"
 
 	geom "github.com/peterstace/simplefeatures/geom"
 	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
 )
 
 func TestGeoStore(t *testing.T) {
@@ -432,3 +438,495 @@ func makeLineString(coords [][]float64) geom.LineString {
 	seq := geom.NewSequence(flatten(coords), geom.DimXY)
 	return geom.NewLineString(seq)
 }
+
+// TestOverwriteRemovesStaleTerms validates that re-putting an ID drops the terms of the previous geometry
+func TestOverwriteRemovesStaleTerms(t *testing.T) {
+	// Setup temporary DB
+	tmpFile, err := os.CreateTemp("", "geo_overwrite_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewGeoStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Same ID, first in Toronto then moved to Montreal
+	if err := store.Put("moving", makeGeoJSON("moving", -79.3871, 43.6426, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("moving", makeGeoJSON("moving", -73.5673, 45.5017, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := store.FindClosest(43.6426, -79.3871, 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected no result at the old location, got %d", len(results))
+	}
+
+	results, err = store.FindClosest(45.5017, -73.5673, 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected 1 result at the new location, got %d", len(results))
+	}
+
+	// Only the terms of the current geometry remain
+	entry, err := store.PrepareIndexEntry("moving", geom.GeoJSONFeature{Geometry: geom.NewPointXY(-73.5673, 45.5017).AsGeometry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := 0
+	err = store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketIndex)).ForEach(func(k, v []byte) error {
+			keys++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(entry.InteriorTerms) + len(entry.ExteriorTerms); keys != want {
+		t.Errorf("Expected %d index keys, got %d", want, keys)
+	}
+
+	found, err := store.Has("moving")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Error("Expected Has to report the stored ID")
+	}
+}
+
+// TestWriteBatchCheckpoint validates that checkpoints are committed with their batch
+func TestWriteBatchCheckpoint(t *testing.T) {
+	// Setup temporary DB
+	tmpFile, err := os.CreateTemp("", "geo_checkpoint_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewGeoStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, ok, err := store.Checkpoint("import"); err != nil || ok {
+		t.Fatalf("Expected no checkpoint, got ok=%v err=%v", ok, err)
+	}
+
+	entry, err := store.PrepareIndexEntry("cn_tower", geom.GeoJSONFeature{Geometry: geom.NewPointXY(-79.3871, 43.6426).AsGeometry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Checkpoint{Offset: 1234, Ordinal: 1, Fingerprint: "size:5678", Handled: map[int64]int64{3: 2345}}
+	if err := store.WriteBatchCheckpoint([]IndexEntry{entry}, "import", want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok, err := store.Checkpoint("import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected checkpoint %+v, got %+v (ok=%v)", want, got, ok)
+	}
+	if found, _ := store.Has("cn_tower"); !found {
+		t.Error("Expected the batch to be written with the checkpoint")
+	}
+}
+
+// TestGeometryCollection validates that collections are indexed and returned with their members
+func TestGeometryCollection(t *testing.T) {
+	// Setup temporary DB
+	tmpFile, err := os.CreateTemp("", "geo_collection_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewGeoStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	poly := geom.NewPolygon([]geom.LineString{
+		makeLineString([][]float64{{-79.40, 43.64}, {-79.37, 43.64}, {-79.37, 43.66}, {-79.40, 43.66}, {-79.40, 43.64}}),
+	})
+	gc := geom.NewGeometryCollection([]geom.Geometry{
+		geom.NewPointXY(-73.5673, 45.5017).AsGeometry(),
+		poly.AsGeometry(),
+	})
+	b, err := geom.GeoJSONFeature{Geometry: gc.AsGeometry()}.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("collection", b); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both members are indexed
+	for _, q := range [][2]float64{{43.65, -79.385}, {45.5017, -73.5673}} {
+		results, err := store.FindClosest(q[0], q[1], 100, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("Expected 1 result near %v, got %d", q, len(results))
+		}
+		g := results[0].Geometry
+		if g.Type() != geom.TypeGeometryCollection || g.MustAsGeometryCollection().NumGeometries() != 2 {
+			t.Errorf("Expected a GeometryCollection of 2 members, got %s", g.AsText())
+		}
+	}
+}
+
+// TestGetRoundTrip validates that geometry types survive the store, and coordinates too when kept
+func TestGetRoundTrip(t *testing.T) {
+	poly1 := makeLineString([][]float64{{-80.0, 43.0}, {-79.5, 43.0}, {-79.5, 43.5}, {-80.0, 43.5}, {-80.0, 43.0}})
+	hole1 := makeLineString([][]float64{{-79.9, 43.1}, {-79.9, 43.2}, {-79.8, 43.2}, {-79.8, 43.1}, {-79.9, 43.1}})
+	poly2 := makeLineString([][]float64{{-79.0, 44.0}, {-78.5, 44.0}, {-78.5, 44.5}, {-79.0, 44.5}, {-79.0, 44.0}})
+
+	geoms := map[string]geom.Geometry{
+		"point":      geom.NewPointXY(-79.3871, 43.6426).AsGeometry(),
+		"multipoint": geom.NewMultiPoint([]geom.Point{geom.NewPointXY(-79.3871, 43.6426)}).AsGeometry(),
+		"multiline":  geom.NewMultiLineString([]geom.LineString{makeLineString([][]float64{{-79.0, 43.0}, {-78.0, 44.0}})}).AsGeometry(),
+		"polygon":    geom.NewPolygon([]geom.LineString{poly1, hole1}).AsGeometry(),
+		"multipolygon": geom.NewMultiPolygon([]geom.Polygon{
+			geom.NewPolygon([]geom.LineString{poly1, hole1}),
+			geom.NewPolygon([]geom.LineString{poly2}),
+		}).AsGeometry(),
+	}
+
+	for _, keep := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.KeepOriginalGeometry = keep
+		store := newTestStore(t, opts)
+
+		for id, g := range geoms {
+			b, err := geom.GeoJSONFeature{Geometry: g, Properties: map[string]any{"id": id}}.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(id, b); err != nil {
+				t.Fatal(err)
+			}
+
+			item, err := store.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.Geometry.Type() != g.Type() {
+				t.Errorf("keep=%v %s: expected type %s, got %s", keep, id, g.Type(), item.Geometry.Type())
+			}
+			if keep && !geom.ExactEquals(item.Geometry, g) {
+				t.Errorf("keep=%v %s: expected exact geometry %s, got %s", keep, id, g.AsText(), item.Geometry.AsText())
+			}
+			if !keep && !geom.ExactEquals(item.Geometry, g, geom.ToleranceXY(1e-9)) {
+				t.Errorf("keep=%v %s: expected geometry %s, got %s", keep, id, g.AsText(), item.Geometry.AsText())
+			}
+			if item.Properties["id"] != id {
+				t.Errorf("keep=%v %s: unexpected properties %v", keep, id, item.Properties)
+			}
+		}
+	}
+
+	store := newTestStore(t, DefaultOptions())
+	if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// TestZMRoundTrip validates that Z and M ordinates are kept through the side channel
+func TestZMRoundTrip(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+
+	trail := geom.NewLineString(geom.NewSequence([]float64{
+		-79.40, 43.64, 120.5, -79.37, 43.64, 130.25, -79.37, 43.66, 98.0,
+	}, geom.DimXYZ)).AsGeometry()
+	// Clockwise shell fixed by the validation, Z values must follow their vertices
+	park := geom.NewPolygon([]geom.LineString{
+		geom.NewLineString(geom.NewSequence([]float64{
+			-80.0, 43.0, 1, 10, -80.0, 45.0, 2, 20, -78.0, 45.0, 3, 30, -78.0, 43.0, 4, 40, -80.0, 43.0, 1, 10,
+		}, geom.DimXYZM)),
+		geom.NewLineString(geom.NewSequence([]float64{
+			-79.9, 43.1, 5, 50, -79.9, 43.2, 6, 60, -79.8, 43.2, 7, 70, -79.8, 43.1, 8, 80, -79.9, 43.1, 5, 50,
+		}, geom.DimXYZM)),
+	}).AsGeometry()
+
+	for id, g := range map[string]geom.Geometry{"trail": trail, "park": park} {
+		entry, err := store.PrepareIndexEntry(id, geom.GeoJSONFeature{Geometry: g})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Geometry.CoordinatesType() != g.CoordinatesType() {
+			t.Fatalf("%s: expected %s coordinates, got %s", id, g.CoordinatesType(), item.Geometry.CoordinatesType())
+		}
+
+		// Compare each vertex Z/M by position
+		want := make(map[[2]float64][2]float64)
+		seq := g.DumpCoordinates()
+		for i := 0; i < seq.Length(); i++ {
+			c := seq.Get(i)
+			want[[2]float64{c.X, c.Y}] = [2]float64{c.Z, c.M}
+		}
+		got := item.Geometry.DumpCoordinates()
+		for i := 0; i < got.Length(); i++ {
+			c := got.Get(i)
+			var zm [2]float64
+			found := false
+			for xy, v := range want {
+				if math.Abs(xy[0]-c.X) < 1e-9 && math.Abs(xy[1]-c.Y) < 1e-9 {
+					zm, found = v, true
+				}
+			}
+			if !found || zm != [2]float64{c.Z, c.M} {
+				t.Errorf("%s: vertex (%g, %g) has Z/M (%g, %g), expected %v", id, c.X, c.Y, c.Z, c.M, zm)
+			}
+		}
+	}
+}
+
+// TestZMRepeatedPositions validates that a position repeated along a line keeps the Z of each occurrence
+func TestZMRepeatedPositions(t *testing.T) {
+	// Out and back, then a loop back to the start, climbing all along
+	coords := []float64{
+		-79.40, 43.64, 100, -79.39, 43.64, 110, -79.38, 43.64, 120, -79.39, 43.64, 130,
+		-79.40, 43.64, 140, -79.40, 43.65, 150, -79.40, 43.64, 160,
+	}
+	trail := geom.NewLineString(geom.NewSequence(coords, geom.DimXYZ)).AsGeometry()
+
+	for _, enc := range []ShapeEncoding{EncodingLossless, EncodingE7} {
+		opts := DefaultOptions()
+		opts.Encoding = enc
+		store := newTestStore(t, opts)
+		entry, err := store.PrepareIndexEntry("trail", geom.GeoJSONFeature{Geometry: trail})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+		item, err := store.Get("trail")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := item.Geometry.DumpCoordinates()
+		if got.Length() != len(coords)/3 {
+			t.Fatalf("%s: expected %d vertices, got %d", enc, len(coords)/3, got.Length())
+		}
+		for i := range got.Length() {
+			if z := got.Get(i).Z; z != coords[i*3+2] {
+				t.Errorf("%s: vertex %d has Z %g, expected %g", enc, i, z, coords[i*3+2])
+			}
+		}
+	}
+}
+
+// TestDelete validates Delete removes the object and all its index keys, with every layout
+func TestDelete(t *testing.T) {
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+		opts := DefaultOptions()
+		opts.IndexLayout = l
+		store := newTestStore(t, opts)
+		writeGrid(t, store, 2, 16)
+		keys := countKeys(t, store)
+		writeGrid(t, store, 3, 16)
+
+		if err := store.Delete("circle-2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get("circle-2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound after delete, got %v", l, err)
+		}
+		if n := countKeys(t, store); n != keys {
+			t.Errorf("%s: %d index keys after delete, want %d", l, n, keys)
+		}
+		if ids := resultIDs(t, store, 43.5, -79.48, 10); len(ids) != 0 {
+			t.Errorf("%s: deleted object still found %v", l, ids)
+		}
+		if err := store.Delete("circle-2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound deleting twice, got %v", l, err)
+		}
+
+		if l == IndexLayoutCompact {
+			err := store.db.View(func(tx *bolt.Tx) error {
+				if n := tx.Bucket([]byte(bucketSeqs)).Stats().KeyN; n != 2 {
+					t.Errorf("%d sequence mappings after delete, want 2", n)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Written again, it is found again
+		writeGrid(t, store, 3, 16)
+		if ids := resultIDs(t, store, 43.5, -79.48, 10); !slices.Equal(ids, []string{"circle-2@0"}) {
+			t.Errorf("%s: got %v after writing again", l, ids)
+		}
+	}
+}
+
+// TestRepairCorrupted validates an object whose blob can't be decoded can be queried around, overwritten
+// and deleted, with every layout, whether it isn't a blob at all or a truncated one
+func TestRepairCorrupted(t *testing.T) {
+	corruptions := map[string]func(blob []byte) []byte{
+		"not a blob": func([]byte) []byte { return []byte("not a blob") },
+		"truncated":  func(blob []byte) []byte { return blob[:len(blob)/2] },
+		"last byte":  func(blob []byte) []byte { return blob[:len(blob)-1] },
+		"shapes":     func(blob []byte) []byte { return blob[:len(blob)-len(blob)/4] },
+	}
+	corrupt := func(t *testing.T, store *GeoStore, id string, fn func([]byte) []byte) {
+		t.Helper()
+		err := store.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(bucketObjects))
+			return b.Put([]byte(id), fn(bytes.Clone(b.Get([]byte(id)))))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, fn := range corruptions {
+		for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+			opts := DefaultOptions()
+			opts.IndexLayout = l
+			store := newTestStore(t, opts)
+			writeGrid(t, store, 2, 16)
+			keys := countKeys(t, store)
+			writeGrid(t, store, 3, 16)
+			all := countKeys(t, store)
+
+			// Queried around, the corrupted object is skipped
+			corrupt(t, store, "circle-2", fn)
+			if results, err := store.FindClosest(43.5, -79.48, 5000, true); err != nil || len(results) != 2 {
+				t.Errorf("%s %s: expected the 2 other objects, got %d, %v", name, l, len(results), err)
+			}
+
+			// Overwritten, the keys of the corrupted object are replaced
+			writeGrid(t, store, 3, 16)
+			if _, err := store.Get("circle-2"); err != nil {
+				t.Errorf("%s %s: overwritten object: %v", name, l, err)
+			}
+			if n := countKeys(t, store); n != all {
+				t.Errorf("%s %s: %d index keys after overwrite, want %d", name, l, n, all)
+			}
+
+			corrupt(t, store, "circle-2", fn)
+			if _, err := store.Get("circle-2"); err == nil {
+				t.Errorf("%s %s: expected an error getting a corrupted object", name, l)
+			}
+			if err := store.Delete("circle-2"); err != nil {
+				t.Fatalf("%s %s: deleting a corrupted object: %v", name, l, err)
+			}
+			if n := countKeys(t, store); n != keys {
+				t.Errorf("%s %s: %d index keys after delete, want %d", name, l, n, keys)
+			}
+			if _, err := store.Get("circle-1"); err != nil {
+				t.Errorf("%s %s: other object: %v", name, l, err)
+			}
+		}
+	}
+}
+
+// TestReadOnly validates a store opened read only can be queried by several readers
+// and is never written, even when it predates the meta bucket
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	if _, err := NewGeoStoreWithOptions(filepath.Join(dir, "missing.db"), opts); err == nil {
+		t.Error("expected an error opening a missing store read only")
+	}
+
+	// A store written before the meta bucket, with the baseline buckets only
+	dbPath := filepath.Join(dir, "geo.db")
+	store, err := NewGeoStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeGrid(t, store, 4, 16)
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(bucketMeta))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readers := make([]*GeoStore, 2)
+	for i := range readers {
+		if readers[i], err = NewGeoStoreWithOptions(dbPath, opts); err != nil {
+			t.Fatal(err)
+		}
+		defer readers[i].Close()
+	}
+	for _, r := range readers {
+		if r.IndexLayout() != IndexLayoutTerms {
+			t.Errorf("expected the terms layout, got %s", r.IndexLayout())
+		}
+		results, err := r.FindClosest(43.5, -79.5, 5000, false)
+		if err != nil || len(results) != 4 {
+			t.Errorf("expected 4 results, got %d, %v", len(results), err)
+		}
+		if _, ok, err := r.Checkpoint("import"); ok || err != nil {
+			t.Errorf("expected no checkpoint, got %v, %v", ok, err)
+		}
+	}
+	if err := readers[0].Delete("circle-0"); !errors.Is(err, bolterrors.ErrDatabaseReadOnly) {
+		t.Errorf("expected a read only error, got %v", err)
+	}
+	for _, r := range readers {
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after, err := os.ReadFile(dbPath); err != nil || !bytes.Equal(before, after) {
+		t.Errorf("expected the store unchanged, %v", err)
+	}
+
+	// Not a store
+	empty := filepath.Join(dir, "empty.db")
+	db, err := bolt.Open(empty, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := NewGeoStoreWithOptions(empty, opts); err == nil {
+		t.Error("expected an error opening a database without the store buckets")
+	}
+}
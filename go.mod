@@ -3,12 +3,30 @@ module github.com/akhenakh/geobbolt
 go 1.25.5
 
 require (
+	github.com/fxamacker/cbor/v2 v2.9.4
 	github.com/golang/geo v0.0.0-20251209161508-25c597310d4b
-	github.com/google/uuid v1.6.0
+	github.com/hashicorp/golang-lru/v2 v2.0.7
+	github.com/klauspost/compress v1.18.0
 	github.com/peterstace/simplefeatures v0.56.0
+	github.com/prometheus/client_golang v1.22.0
 	go.etcd.io/bbolt v1.4.3
+	google.golang.org/grpc v1.71.1
+	google.golang.org/protobuf v1.36.5
 )
 
-require golang.org/x/sys v0.29.0 // indirect
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.62.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
+)
 
 replace github.com/golang/geo => github.com/akhenakh/geo v0.0.0-20260101161651-4227fdd81f2e
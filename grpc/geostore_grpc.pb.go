@@ -0,0 +1,368 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: geostore.proto
+
+package grpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	GeoStore_Put_FullMethodName            = "/geostore.v1.GeoStore/Put"
+	GeoStore_Delete_FullMethodName         = "/geostore.v1.GeoStore/Delete"
+	GeoStore_Get_FullMethodName            = "/geostore.v1.GeoStore/Get"
+	GeoStore_FindClosest_FullMethodName    = "/geostore.v1.GeoStore/FindClosest"
+	GeoStore_FindContaining_FullMethodName = "/geostore.v1.GeoStore/FindContaining"
+	GeoStore_BulkLoad_FullMethodName       = "/geostore.v1.GeoStore/BulkLoad"
+	GeoStore_StreamClosest_FullMethodName  = "/geostore.v1.GeoStore/StreamClosest"
+)
+
+// GeoStoreClient is the client API for GeoStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// GeoStore stores GeoJSON features and queries them by location.
+type GeoStoreClient interface {
+	// Put stores a feature, replacing the feature with the same id.
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// Delete removes a feature, NOT_FOUND when there is none with the id.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Get returns a feature with its geometry, NOT_FOUND when there is none with the id.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// FindClosest returns the features within a radius, closest first.
+	FindClosest(ctx context.Context, in *FindClosestRequest, opts ...grpc.CallOption) (*FindResponse, error)
+	// FindContaining returns the features covering a location.
+	FindContaining(ctx context.Context, in *FindContainingRequest, opts ...grpc.CallOption) (*FindResponse, error)
+	// BulkLoad stores a stream of features in batches. Features failing validation
+	// are reported as rejected, the others are stored.
+	BulkLoad(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PutRequest, BulkLoadResponse], error)
+	// StreamClosest streams the features within a radius as they are found, in
+	// no particular order, each with its distance.
+	StreamClosest(ctx context.Context, in *FindClosestRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Feature], error)
+}
+
+type geoStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGeoStoreClient(cc grpc.ClientConnInterface) GeoStoreClient {
+	return &geoStoreClient{cc}
+}
+
+func (c *geoStoreClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, GeoStore_Put_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *geoStoreClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, GeoStore_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *geoStoreClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, GeoStore_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *geoStoreClient) FindClosest(ctx context.Context, in *FindClosestRequest, opts ...grpc.CallOption) (*FindResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FindResponse)
+	err := c.cc.Invoke(ctx, GeoStore_FindClosest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *geoStoreClient) FindContaining(ctx context.Context, in *FindContainingRequest, opts ...grpc.CallOption) (*FindResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FindResponse)
+	err := c.cc.Invoke(ctx, GeoStore_FindContaining_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *geoStoreClient) BulkLoad(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PutRequest, BulkLoadResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &GeoStore_ServiceDesc.Streams[0], GeoStore_BulkLoad_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PutRequest, BulkLoadResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GeoStore_BulkLoadClient = grpc.ClientStreamingClient[PutRequest, BulkLoadResponse]
+
+func (c *geoStoreClient) StreamClosest(ctx context.Context, in *FindClosestRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Feature], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &GeoStore_ServiceDesc.Streams[1], GeoStore_StreamClosest_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[FindClosestRequest, Feature]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GeoStore_StreamClosestClient = grpc.ServerStreamingClient[Feature]
+
+// GeoStoreServer is the server API for GeoStore service.
+// All implementations must embed UnimplementedGeoStoreServer
+// for forward compatibility.
+//
+// GeoStore stores GeoJSON features and queries them by location.
+type GeoStoreServer interface {
+	// Put stores a feature, replacing the feature with the same id.
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// Delete removes a feature, NOT_FOUND when there is none with the id.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Get returns a feature with its geometry, NOT_FOUND when there is none with the id.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// FindClosest returns the features within a radius, closest first.
+	FindClosest(context.Context, *FindClosestRequest) (*FindResponse, error)
+	// FindContaining returns the features covering a location.
+	FindContaining(context.Context, *FindContainingRequest) (*FindResponse, error)
+	// BulkLoad stores a stream of features in batches. Features failing validation
+	// are reported as rejected, the others are stored.
+	BulkLoad(grpc.ClientStreamingServer[PutRequest, BulkLoadResponse]) error
+	// StreamClosest streams the features within a radius as they are found, in
+	// no particular order, each with its distance.
+	StreamClosest(*FindClosestRequest, grpc.ServerStreamingServer[Feature]) error
+	mustEmbedUnimplementedGeoStoreServer()
+}
+
+// UnimplementedGeoStoreServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGeoStoreServer struct{}
+
+func (UnimplementedGeoStoreServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedGeoStoreServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedGeoStoreServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedGeoStoreServer) FindClosest(context.Context, *FindClosestRequest) (*FindResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindClosest not implemented")
+}
+func (UnimplementedGeoStoreServer) FindContaining(context.Context, *FindContainingRequest) (*FindResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindContaining not implemented")
+}
+func (UnimplementedGeoStoreServer) BulkLoad(grpc.ClientStreamingServer[PutRequest, BulkLoadResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method BulkLoad not implemented")
+}
+func (UnimplementedGeoStoreServer) StreamClosest(*FindClosestRequest, grpc.ServerStreamingServer[Feature]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamClosest not implemented")
+}
+func (UnimplementedGeoStoreServer) mustEmbedUnimplementedGeoStoreServer() {}
+func (UnimplementedGeoStoreServer) testEmbeddedByValue()                  {}
+
+// UnsafeGeoStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GeoStoreServer will
+// result in compilation errors.
+type UnsafeGeoStoreServer interface {
+	mustEmbedUnimplementedGeoStoreServer()
+}
+
+func RegisterGeoStoreServer(s grpc.ServiceRegistrar, srv GeoStoreServer) {
+	// If the following call pancis, it indicates UnimplementedGeoStoreServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&GeoStore_ServiceDesc, srv)
+}
+
+func _GeoStore_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeoStoreServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GeoStore_Put_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeoStoreServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GeoStore_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeoStoreServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GeoStore_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeoStoreServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GeoStore_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeoStoreServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GeoStore_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeoStoreServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GeoStore_FindClosest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindClosestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeoStoreServer).FindClosest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GeoStore_FindClosest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeoStoreServer).FindClosest(ctx, req.(*FindClosestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GeoStore_FindContaining_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindContainingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeoStoreServer).FindContaining(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GeoStore_FindContaining_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeoStoreServer).FindContaining(ctx, req.(*FindContainingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GeoStore_BulkLoad_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GeoStoreServer).BulkLoad(&grpc.GenericServerStream[PutRequest, BulkLoadResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GeoStore_BulkLoadServer = grpc.ClientStreamingServer[PutRequest, BulkLoadResponse]
+
+func _GeoStore_StreamClosest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FindClosestRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GeoStoreServer).StreamClosest(m, &grpc.GenericServerStream[FindClosestRequest, Feature]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GeoStore_StreamClosestServer = grpc.ServerStreamingServer[Feature]
+
+// GeoStore_ServiceDesc is the grpc.ServiceDesc for GeoStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GeoStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "geostore.v1.GeoStore",
+	HandlerType: (*GeoStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Put",
+			Handler:    _GeoStore_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _GeoStore_Delete_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _GeoStore_Get_Handler,
+		},
+		{
+			MethodName: "FindClosest",
+			Handler:    _GeoStore_FindClosest_Handler,
+		},
+		{
+			MethodName: "FindContaining",
+			Handler:    _GeoStore_FindContaining_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BulkLoad",
+			Handler:       _GeoStore_BulkLoad_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "StreamClosest",
+			Handler:       _GeoStore_StreamClosest_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "geostore.proto",
+}
@@ -0,0 +1,345 @@
+// Package grpc serves a GeoStore over gRPC, see geostore.proto for the service.
+//
+//	srv := grpc.NewServer()
+//	geogrpc.RegisterGeoStoreServer(srv, geogrpc.NewServer(store))
+package grpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative geostore.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+
+	geostore "github.com/akhenakh/geobbolt"
+	geom "github.com/peterstace/simplefeatures/geom"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// bulkBatchSize is the number of features written per transaction by BulkLoad.
+const bulkBatchSize = 1000
+
+// maxRejections is the number of rejections listed in the BulkLoad response, the
+// rejections past it are only counted.
+const maxRejections = 100
+
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// MaxRadius is the largest radius in meters accepted by the closest queries,
+	// 0 accepts any radius.
+	MaxRadius float64
+
+	// Logger, when set, receives the internal errors, which are not sent to clients.
+	Logger *slog.Logger
+}
+
+// DefaultServerOptions returns the options used by NewServer.
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{MaxRadius: 50000}
+}
+
+// Server implements GeoStoreServer backed by a GeoStore.
+type Server struct {
+	UnimplementedGeoStoreServer
+
+	store *geostore.GeoStore
+	opts  ServerOptions
+}
+
+var _ GeoStoreServer = (*Server)(nil)
+
+// NewServer returns a server for store with DefaultServerOptions, the store stays
+// owned by the caller.
+func NewServer(store *geostore.GeoStore) *Server {
+	return NewServerWithOptions(store, DefaultServerOptions())
+}
+
+// NewServerWithOptions returns a server for store configured with opts.
+func NewServerWithOptions(store *geostore.GeoStore, opts ServerOptions) *Server {
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	return &Server{store: store, opts: opts}
+}
+
+func (s *Server) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	entry, err := s.prepare(req.GetFeature())
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	if err := s.store.WriteBatch([]geostore.IndexEntry{entry}); err != nil {
+		return nil, s.statusError(err)
+	}
+	return &PutResponse{}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	if err := s.store.Delete(req.GetId()); err != nil {
+		return nil, s.statusError(err)
+	}
+	return &DeleteResponse{}, nil
+}
+
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	item, err := s.store.Get(req.GetId())
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	f, err := toFeature(item, true)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	return &GetResponse{Feature: f}, nil
+}
+
+func (s *Server) FindClosest(ctx context.Context, req *FindClosestRequest) (*FindResponse, error) {
+	if err := s.validateClosest(req); err != nil {
+		return nil, s.statusError(err)
+	}
+	items, err := s.store.FindClosestWithOptions(req.GetLat(), req.GetLng(), req.GetRadiusMeters(), queryOptions(ctx, req.GetOptions()))
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	return s.toFindResponse(items, req.GetOptions())
+}
+
+func (s *Server) FindContaining(ctx context.Context, req *FindContainingRequest) (*FindResponse, error) {
+	if err := validateLatLng(req.GetLat(), req.GetLng()); err != nil {
+		return nil, s.statusError(err)
+	}
+	items, err := s.store.FindContaining(req.GetLat(), req.GetLng(), queryOptions(ctx, req.GetOptions()))
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	return s.toFindResponse(items, req.GetOptions())
+}
+
+// StreamClosest sends the features as they are refined, in no particular order:
+// the first ones arrive before the query completes, and the query stops with the
+// stream.
+func (s *Server) StreamClosest(req *FindClosestRequest, stream GeoStore_StreamClosestServer) error {
+	if err := s.validateClosest(req); err != nil {
+		return s.statusError(err)
+	}
+	withGeometry := req.GetOptions().GetWithGeometry()
+	err := s.store.EachClosest(req.GetLat(), req.GetLng(), req.GetRadiusMeters(), queryOptions(stream.Context(), req.GetOptions()),
+		func(item geostore.StoredItem) error {
+			f, err := toFeature(item, withGeometry)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(f); err != nil {
+				return sendError{err}
+			}
+			return nil
+		})
+	var serr sendError
+	if errors.As(err, &serr) {
+		return serr.error
+	}
+	if err != nil {
+		return s.statusError(err)
+	}
+	return nil
+}
+
+// sendError is an error of the stream, returned as is.
+type sendError struct{ error }
+
+// BulkLoad writes the features of the stream in batches of bulkBatchSize. A
+// feature failing validation is rejected, the first maxRejections are listed in the
+// response. A write error aborts the stream with the batches before it written.
+func (s *Server) BulkLoad(stream GeoStore_BulkLoadServer) error {
+	resp := &BulkLoadResponse{}
+	var batch []geostore.IndexEntry
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.store.WriteBatch(batch); err != nil {
+			st := status.Convert(s.statusError(err))
+			return status.Errorf(st.Code(), "writing after %d features: %s", resp.Written, st.Message())
+		}
+		resp.Written += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for ordinal := int64(0); ; ordinal++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		entry, err := s.prepare(req.GetFeature())
+		if err != nil {
+			resp.RejectedCount++
+			if len(resp.Rejected) < maxRejections {
+				resp.Rejected = append(resp.Rejected, s.rejection(ordinal, req.GetFeature().GetId(), err))
+			}
+			continue
+		}
+		batch = append(batch, entry)
+		if len(batch) >= bulkBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+// rejection reports a feature of a BulkLoad stream that was not stored. Like the
+// status of the other calls, only validation errors are sent with their message.
+func (s *Server) rejection(ordinal int64, id string, err error) *BulkLoadResponse_Rejection {
+	return &BulkLoadResponse_Rejection{
+		Ordinal: ordinal,
+		Id:      id,
+		Reason:  status.Convert(s.statusError(err)).Message(),
+	}
+}
+
+// invalidArgument is a validation error of a request.
+type invalidArgument struct{ error }
+
+func invalidArgumentf(format string, args ...any) error {
+	return invalidArgument{fmt.Errorf(format, args...)}
+}
+
+func (s *Server) prepare(f *Feature) (geostore.IndexEntry, error) {
+	if f.GetId() == "" {
+		return geostore.IndexEntry{}, invalidArgumentf("feature without id")
+	}
+	g, err := geom.UnmarshalGeoJSON(f.GetGeometry())
+	if err != nil {
+		return geostore.IndexEntry{}, invalidArgumentf("invalid geometry: %w", err)
+	}
+	feature := geom.GeoJSONFeature{Geometry: g, Properties: f.GetProperties().AsMap()}
+	return s.store.PrepareIndexEntry(f.GetId(), feature)
+}
+
+func (s *Server) validateClosest(req *FindClosestRequest) error {
+	if err := validateLatLng(req.GetLat(), req.GetLng()); err != nil {
+		return err
+	}
+	switch r := req.GetRadiusMeters(); {
+	case r <= 0:
+		return invalidArgumentf("radius_meters must be positive, got %v", r)
+	case s.opts.MaxRadius > 0 && r > s.opts.MaxRadius:
+		return invalidArgumentf("radius_meters must be at most %v, got %v", s.opts.MaxRadius, r)
+	}
+	return nil
+}
+
+func validateLatLng(lat, lng float64) error {
+	if lat < -90 || lat > 90 {
+		return invalidArgumentf("lat must be in [-90, 90], got %v", lat)
+	}
+	if lng < -180 || lng > 180 {
+		return invalidArgumentf("lng must be in [-180, 180], got %v", lng)
+	}
+	return nil
+}
+
+// queryOptions converts o to the options of a query stopping with ctx.
+func queryOptions(ctx context.Context, o *QueryOptions) geostore.QueryOptions {
+	opts := geostore.QueryOptions{WithGeometry: o.GetWithGeometry(), Context: ctx}
+	switch {
+	case o.GetNoProperties():
+		opts.Properties = []string{}
+	case len(o.GetProperties()) > 0:
+		opts.Properties = o.GetProperties()
+	}
+	return opts
+}
+
+func (s *Server) toFindResponse(items []geostore.StoredItem, o *QueryOptions) (*FindResponse, error) {
+	resp := &FindResponse{Features: make([]*Feature, len(items))}
+	for i, item := range items {
+		f, err := toFeature(item, o.GetWithGeometry())
+		if err != nil {
+			return nil, s.statusError(err)
+		}
+		resp.Features[i] = f
+	}
+	return resp, nil
+}
+
+func toFeature(item geostore.StoredItem, withGeometry bool) (*Feature, error) {
+	f := &Feature{Id: item.ID, Distance: item.Distance}
+	if item.Properties != nil {
+		props, err := structpb.NewStruct(structMap(item.Properties))
+		if err != nil {
+			return nil, fmt.Errorf("properties of %s: %w", item.ID, err)
+		}
+		f.Properties = props
+	}
+	if withGeometry {
+		g, err := item.Geometry.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("geometry of %s: %w", item.ID, err)
+		}
+		f.Geometry = g
+	}
+	return f, nil
+}
+
+// structMap converts the property values structpb doesn't support, the big
+// integers CBOR decodes out of the int64 range, nested in maps and lists too.
+func structMap(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = structValue(v)
+	}
+	return out
+}
+
+func structValue(v any) any {
+	switch v := v.(type) {
+	case big.Int:
+		return structValue(&v)
+	case *big.Int:
+		// Struct numbers are doubles
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f
+	case map[string]any:
+		return structMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = structValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// statusError converts err to a gRPC status. Internal errors are logged and sent
+// without their details.
+func (s *Server) statusError(err error) error {
+	var invalid invalidArgument
+	switch {
+	case errors.As(err, &invalid),
+		errors.Is(err, geostore.ErrEmptyGeometry),
+		errors.Is(err, geostore.ErrUnsupportedGeometry),
+		errors.Is(err, geostore.ErrInvalidGeometry):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, geostore.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		s.opts.Logger.Error("internal error", "error", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,351 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	geostore "github.com/akhenakh/geobbolt"
+	geom "github.com/peterstace/simplefeatures/geom"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newTestClient serves a new store in process and returns a client connected to it.
+func newTestClient(t *testing.T) GeoStoreClient {
+	t.Helper()
+	return serveTest(t, NewServer(newTestStore(t, geostore.DefaultOptions())))
+}
+
+func newTestStore(t *testing.T, opts geostore.Options) *geostore.GeoStore {
+	t.Helper()
+	store, err := geostore.NewGeoStoreWithOptions(filepath.Join(t.TempDir(), "geo.db"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// serveTest serves s in process and returns a client connected to it.
+func serveTest(t *testing.T, s *Server) GeoStoreClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterGeoStoreServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewGeoStoreClient(conn)
+}
+
+func pointFeature(t *testing.T, id string, lng, lat float64) *Feature {
+	t.Helper()
+	props, err := structpb.NewStruct(map[string]any{"name": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Feature{
+		Id:         id,
+		Geometry:   fmt.Appendf(nil, `{"type":"Point","coordinates":[%v,%v]}`, lng, lat),
+		Properties: props,
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	square := &Feature{
+		Id:       "square",
+		Geometry: []byte(`{"type":"Polygon","coordinates":[[[-79.4,43.6],[-79.3,43.6],[-79.3,43.7],[-79.4,43.7],[-79.4,43.6]]]}`),
+	}
+	for _, f := range []*Feature{square, pointFeature(t, "p1", -79.2, 43.65)} {
+		if _, err := client.Put(ctx, &PutRequest{Feature: f}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := client.Get(ctx, &GetRequest{Id: "p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := got.GetFeature().GetProperties().AsMap()["name"]; name != "p1" || len(got.GetFeature().GetGeometry()) == 0 {
+		t.Errorf("unexpected feature %v", got.GetFeature())
+	}
+
+	closest, err := client.FindClosest(ctx, &FindClosestRequest{Lat: 43.65, Lng: -79.22, RadiusMeters: 10000,
+		Options: &QueryOptions{WithGeometry: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs := closest.GetFeatures(); len(fs) != 2 || fs[0].GetId() != "p1" || fs[1].GetId() != "square" ||
+		fs[0].GetDistance() >= fs[1].GetDistance() || len(fs[1].GetGeometry()) == 0 {
+		t.Errorf("unexpected closest features %v", fs)
+	}
+
+	containing, err := client.FindContaining(ctx, &FindContainingRequest{Lat: 43.65, Lng: -79.35,
+		Options: &QueryOptions{NoProperties: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs := containing.GetFeatures(); len(fs) != 1 || fs[0].GetId() != "square" || len(fs[0].GetGeometry()) != 0 {
+		t.Errorf("unexpected containing features %v", fs)
+	}
+
+	if _, err := client.Delete(ctx, &DeleteRequest{Id: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(ctx, &GetRequest{Id: "p1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound after delete, got %v", err)
+	}
+	if _, err := client.Delete(ctx, &DeleteRequest{Id: "p1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound deleting twice, got %v", err)
+	}
+
+	invalid := []struct {
+		name string
+		call func() error
+	}{
+		{"put without id", func() error {
+			_, err := client.Put(ctx, &PutRequest{Feature: &Feature{Geometry: square.Geometry}})
+			return err
+		}},
+		{"put bad geometry", func() error {
+			_, err := client.Put(ctx, &PutRequest{Feature: &Feature{Id: "bad", Geometry: []byte(`{"type":"Point"}`)}})
+			return err
+		}},
+		{"latitude out of range", func() error {
+			_, err := client.FindClosest(ctx, &FindClosestRequest{Lat: 91, RadiusMeters: 10})
+			return err
+		}},
+		{"no radius", func() error {
+			_, err := client.FindClosest(ctx, &FindClosestRequest{Lat: 43.65, Lng: -79.35})
+			return err
+		}},
+		{"radius over the maximum", func() error {
+			_, err := client.FindClosest(ctx, &FindClosestRequest{Lat: 43.65, Lng: -79.35, RadiusMeters: 50001})
+			return err
+		}},
+		{"stream radius over the maximum", func() error {
+			stream, err := client.StreamClosest(ctx, &FindClosestRequest{Lat: 43.65, Lng: -79.35, RadiusMeters: 50001})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}},
+	}
+	for _, c := range invalid {
+		if err := c.call(); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected InvalidArgument, got %v", c.name, err)
+		}
+	}
+}
+
+func TestServerStreams(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	// More features than a batch, with rejected features in between
+	load, err := client.BulkLoad(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := bulkBatchSize + 500
+	for i := range n {
+		f := pointFeature(t, fmt.Sprintf("p%d", i), -79.5+0.0001*float64(i), 43.6)
+		if i%100 == 50 {
+			f.Geometry = []byte("{}")
+		}
+		if err := load.Send(&PutRequest{Feature: f}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := load.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := n / 100
+	if resp.GetWritten() != int64(n-rejected) || len(resp.GetRejected()) != rejected || resp.GetRejectedCount() != int64(rejected) {
+		t.Fatalf("expected %d written and %d rejected, got %d and %d", n-rejected, rejected, resp.GetWritten(), len(resp.GetRejected()))
+	}
+	if r := resp.GetRejected()[1]; r.GetOrdinal() != 150 || r.GetId() != "p150" || !strings.HasPrefix(r.GetReason(), "invalid geometry") {
+		t.Errorf("unexpected rejection %v", r)
+	}
+
+	stream, err := client.StreamClosest(ctx, &FindClosestRequest{Lat: 43.6, Lng: -79.5, RadiusMeters: 1000,
+		Options: &QueryOptions{NoProperties: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Streamed as refined, in no particular order
+	streamed := make(map[string]float64)
+	for {
+		f, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.GetDistance() > 1000 {
+			t.Errorf("%s streamed at %vm", f.GetId(), f.GetDistance())
+		}
+		streamed[f.GetId()] = f.GetDistance()
+	}
+	// 0.0001 degree of longitude is about 8m at this latitude
+	if d, ok := streamed["p0"]; len(streamed) < 100 || !ok || d != 0 {
+		t.Errorf("unexpected streamed features %d, p0 at %v", len(streamed), d)
+	}
+
+	closest, err := client.FindClosest(ctx, &FindClosestRequest{Lat: 43.6, Lng: -79.5, RadiusMeters: 1000,
+		Options: &QueryOptions{NoProperties: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closest.GetFeatures()) != len(streamed) {
+		t.Errorf("streamed %d features, found %d", len(streamed), len(closest.GetFeatures()))
+	}
+}
+
+// closestStream is a server stream failing after limit features.
+type closestStream struct {
+	grpc.ServerStreamingServer[Feature]
+	ctx   context.Context
+	sent  int
+	limit int
+}
+
+func (s *closestStream) Context() context.Context { return s.ctx }
+
+func (s *closestStream) Send(*Feature) error {
+	if s.sent == s.limit {
+		return status.Error(codes.Unavailable, "client gone")
+	}
+	s.sent++
+	return nil
+}
+
+// TestStreamClosestStops validates the query stops with the stream and its context
+func TestStreamClosestStops(t *testing.T) {
+	store := newTestStore(t, geostore.DefaultOptions())
+	for i := range 50 {
+		f := pointFeature(t, fmt.Sprintf("p%d", i), -79.5+0.0001*float64(i), 43.6)
+		if err := store.Put(f.GetId(), fmt.Appendf(nil, `{"type":"Feature","geometry":%s}`, f.GetGeometry())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := NewServer(store)
+	req := &FindClosestRequest{Lat: 43.6, Lng: -79.5, RadiusMeters: 1000}
+
+	stream := &closestStream{ctx: context.Background(), limit: 3}
+	if err := srv.StreamClosest(req, stream); status.Code(err) != codes.Unavailable || stream.sent != 3 {
+		t.Errorf("expected the stream error after 3 features, got %v after %d", err, stream.sent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream = &closestStream{ctx: ctx, limit: 50}
+	if err := srv.StreamClosest(req, stream); status.Code(err) != codes.Canceled || stream.sent != 0 {
+		t.Errorf("expected Canceled before any feature, got %v after %d", err, stream.sent)
+	}
+}
+
+// TestServerProperties validates big integers decoded from CBOR are sent, and
+// internal errors are sent without their details
+func TestServerProperties(t *testing.T) {
+	opts := geostore.DefaultOptions()
+	opts.PropertyEncoding = geostore.PropertiesCBOR
+	store := newTestStore(t, opts)
+	n := new(big.Int).Lsh(big.NewInt(1), 64)
+	feature := geom.GeoJSONFeature{
+		Geometry:   geom.NewPointXY(1, 2).AsGeometry(),
+		Properties: map[string]any{"n": n, "list": []any{map[string]any{"n": new(big.Int).Neg(n)}}},
+	}
+	entry, err := store.PrepareIndexEntry("big", feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteBatch([]geostore.IndexEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	client := serveTest(t, NewServer(store))
+
+	got, err := client.Get(context.Background(), &GetRequest{Id: "big"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := got.GetFeature().GetProperties().AsMap()
+	if props["n"] != 18446744073709551616.0 ||
+		props["list"].([]any)[0].(map[string]any)["n"] != -18446744073709551616.0 {
+		t.Errorf("unexpected properties %v", props)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Get(context.Background(), &GetRequest{Id: "big"})
+	if st := status.Convert(err); st.Code() != codes.Internal || st.Message() != "internal error" {
+		t.Errorf("expected a sanitized internal error, got %v", err)
+	}
+}
+
+// TestBulkLoadRejections validates the rejections past maxRejections are only
+// counted, and internal errors are reported without their details
+func TestBulkLoadRejections(t *testing.T) {
+	client := newTestClient(t)
+	load, err := client.BulkLoad(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := maxRejections + 50
+	for range n {
+		if err := load.Send(&PutRequest{Feature: &Feature{Geometry: []byte("{}")}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := load.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetRejected()) != maxRejections || resp.GetRejectedCount() != int64(n) || resp.GetWritten() != 0 {
+		t.Errorf("expected %d rejections listed out of %d, got %d out of %d",
+			maxRejections, n, len(resp.GetRejected()), resp.GetRejectedCount())
+	}
+	if r := resp.GetRejected()[0]; r.GetReason() != "feature without id" {
+		t.Errorf("unexpected rejection %v", r)
+	}
+
+	var logs bytes.Buffer
+	s := NewServerWithOptions(nil, ServerOptions{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	r := s.rejection(3, "a", fmt.Errorf("encoding properties of /var/lib/geo.db: %w", io.ErrShortWrite))
+	if r.GetOrdinal() != 3 || r.GetId() != "a" || r.GetReason() != "internal error" {
+		t.Errorf("expected a sanitized rejection, got %v", r)
+	}
+	if !strings.Contains(logs.String(), "/var/lib/geo.db") {
+		t.Errorf("expected the internal error logged, got %s", logs.String())
+	}
+	r = s.rejection(4, "b", fmt.Errorf("b: %w", geostore.ErrEmptyGeometry))
+	if r.GetReason() != "b: geometry is empty" {
+		t.Errorf("expected the geometry error reported, got %v", r)
+	}
+}
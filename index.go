@@ -0,0 +1,478 @@
+package geostore
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/golang/geo/s2"
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// IndexLayout selects how the coverings of the objects are keyed in the index bucket.
+type IndexLayout byte
+
+const (
+	// IndexLayoutTerms keys "int:"/"ext:" + Term + \x00 + ID, with a term for every
+	// covering cell and every ancestor of it. A query seeks every term of the query
+	// covering and its ancestors, once per prefix.
+	IndexLayoutTerms IndexLayout = 0
+
+	// IndexLayoutCells keys [CellID big-endian][Kind][ID], Kind being 'i' (interior)
+	// or 'e' (exterior), with a key for every covering cell only. Keys follow the
+	// Hilbert curve: a query scans the range of each query cell for the objects
+	// covered by its descendants and seeks its ancestors, both kinds at once.
+	// The index is about 4 times smaller and small queries are faster, but a wide
+	// query scans every key of the objects it covers where terms match one key
+	// per object and query term.
+	IndexLayoutCells IndexLayout = 1
+
+	// IndexLayoutCompact is IndexLayoutCells with the ID replaced by a sequence
+	// number assigned to each object, [CellID big-endian][Kind][Seq big-endian]
+	// with an empty value, so long IDs aren't repeated for every cell. Sequence
+	// numbers are mapped to IDs in bucketIDs and back in bucketSeqs.
+	IndexLayoutCompact IndexLayout = 2
+)
+
+const metaLayout = "layout" // Key in bucketMeta, Value: IndexLayout byte
+
+const (
+	kindInterior = 'i'
+	kindExterior = 'e'
+)
+
+func (l IndexLayout) String() string {
+	switch l {
+	case IndexLayoutTerms:
+		return "terms"
+	case IndexLayoutCells:
+		return "cells"
+	case IndexLayoutCompact:
+		return "compact"
+	}
+	return fmt.Sprintf("IndexLayout(%d)", byte(l))
+}
+
+// ParseIndexLayout parses "terms", "cells" or "compact".
+func ParseIndexLayout(s string) (IndexLayout, error) {
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+		if l.String() == s {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown index layout %q (want terms, cells or compact)", s)
+}
+
+// indexLayout maps the coverings of the objects to index keys.
+type indexLayout interface {
+	// value returns the value of the index keys.
+	value() []byte
+
+	// cover fills the index terms or cells of entry from its regions.
+	cover(entry *IndexEntry, regions []s2.Region)
+
+	// keys returns the index keys of entry. With assign, the object is given the
+	// internal identifiers the layout needs when it has none yet, otherwise an
+	// object without them has no keys.
+	keys(tx *bolt.Tx, entry IndexEntry, assign bool) ([][]byte, error)
+
+	// scanKeys returns the index keys of the object id by scanning the whole index,
+	// for an object whose stored blob can't be decoded to regenerate them.
+	scanKeys(tx *bolt.Tx, id string) ([][]byte, error)
+
+	// candidates calls fn for the objects with a key matching the query region,
+	// interior is true when the key comes from an interior covering. An object
+	// may be given several times, id is only valid during the call. Lookups are
+	// recorded in trace when explaining.
+	candidates(tx *bolt.Tx, query s2.Region, fn func(id []byte, interior bool), trace *queryTrace) error
+}
+
+// indexLayout returns the implementation of l.
+func (gs *GeoStore) indexLayout(l IndexLayout) (indexLayout, error) {
+	switch l {
+	case IndexLayoutTerms:
+		return termsLayout{gs: gs}, nil
+	case IndexLayoutCells:
+		return cellsLayout{rc: gs.coverer()}, nil
+	case IndexLayoutCompact:
+		return cellsLayout{rc: gs.coverer(), compact: true}, nil
+	}
+	return nil, fmt.Errorf("unknown index layout %d", byte(l))
+}
+
+// IndexLayout returns the index layout of the store.
+func (gs *GeoStore) IndexLayout() IndexLayout {
+	return IndexLayout(gs.layout.Load())
+}
+
+// txLayout returns the index layout recorded in tx.
+func (gs *GeoStore) txLayout(tx *bolt.Tx) (IndexLayout, indexLayout, error) {
+	l := IndexLayoutTerms
+	// Opened read only, stores created before the layout was recorded have no meta bucket
+	if meta := tx.Bucket([]byte(bucketMeta)); meta != nil {
+		if v := meta.Get([]byte(metaLayout)); len(v) > 0 {
+			l = IndexLayout(v[0])
+		}
+	}
+	impl, err := gs.indexLayout(l)
+	return l, impl, err
+}
+
+// loadLayout reads the index layout recorded in the store.
+func (gs *GeoStore) loadLayout(tx *bolt.Tx) error {
+	l, _, err := gs.txLayout(tx)
+	gs.layout.Store(uint32(l))
+	return err
+}
+
+// initLayout records the index layout of a new store. Stores indexed before the
+// layout was recorded keep the terms layout.
+func initLayout(tx *bolt.Tx, l IndexLayout) error {
+	meta := tx.Bucket([]byte(bucketMeta))
+	if meta.Get([]byte(metaLayout)) != nil {
+		return nil
+	}
+	if k, _ := tx.Bucket([]byte(bucketIndex)).Cursor().First(); k != nil {
+		l = IndexLayoutTerms
+	}
+	return meta.Put([]byte(metaLayout), []byte{byte(l)})
+}
+
+// coverer returns a RegionCoverer with the same options as the indexer.
+func (gs *GeoStore) coverer() *s2.RegionCoverer {
+	return &s2.RegionCoverer{
+		MinLevel: gs.indexer.Options.MinLevel,
+		MaxLevel: gs.indexer.Options.MaxLevel,
+		MaxCells: gs.indexer.Options.MaxCells,
+	}
+}
+
+// Reindex rebuilds the index in layout l from the stored objects, in a single
+// transaction. It returns the number of objects indexed.
+func (gs *GeoStore) Reindex(l IndexLayout) (int, error) {
+	layout, err := gs.indexLayout(l)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	err = gs.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{bucketIDs, bucketSeqs} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+				return err
+			}
+		}
+		if err := tx.DeleteBucket([]byte(bucketIndex)); err != nil {
+			return err
+		}
+		bIdx, err := tx.CreateBucket([]byte(bucketIndex))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket([]byte(bucketObjects)).ForEach(func(k, v []byte) error {
+			entry, err := decodeFullEntry(v)
+			if err != nil {
+				return fmt.Errorf("entry %s: %w", k, err)
+			}
+			ie := IndexEntry{ID: string(k)}
+			layout.cover(&ie, shapesToRegions(entry.shapes()))
+			keys, err := layout.keys(tx, ie, true)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err := bIdx.Put(key, layout.value()); err != nil {
+					return err
+				}
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(bucketMeta)).Put([]byte(metaLayout), []byte{byte(l)})
+	})
+	if err != nil {
+		return 0, err
+	}
+	gs.layout.Store(uint32(l))
+	gs.opts.Logger.Debug("reindexed", "layout", l.String(), "objects", n)
+	return n, nil
+}
+
+// termsLayout implements IndexLayoutTerms.
+type termsLayout struct {
+	gs *GeoStore
+}
+
+func (t termsLayout) cover(entry *IndexEntry, regions []s2.Region) {
+	entry.InteriorTerms, entry.ExteriorTerms = t.gs.coverTerms(regions)
+}
+
+func (termsLayout) value() []byte { return []byte("1") }
+
+func (termsLayout) keys(_ *bolt.Tx, entry IndexEntry, _ bool) ([][]byte, error) {
+	keys := make([][]byte, 0, len(entry.InteriorTerms)+len(entry.ExteriorTerms))
+	for _, term := range entry.InteriorTerms {
+		keys = append(keys, indexKey("int:", term, entry.ID))
+	}
+	for _, term := range entry.ExteriorTerms {
+		keys = append(keys, indexKey("ext:", term, entry.ID))
+	}
+	return keys, nil
+}
+
+func (termsLayout) scanKeys(tx *bolt.Tx, id string) ([][]byte, error) {
+	var keys [][]byte
+	c := tx.Bucket([]byte(bucketIndex)).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		// Terms have no \x00, the ID follows the first one
+		if i := bytes.IndexByte(k, 0); i >= 0 && string(k[i+1:]) == id {
+			keys = append(keys, bytes.Clone(k))
+		}
+	}
+	return keys, nil
+}
+
+func (t termsLayout) candidates(tx *bolt.Tx, query s2.Region, fn func(id []byte, interior bool), trace *queryTrace) error {
+	c := tx.Bucket([]byte(bucketIndex)).Cursor()
+	queryTerms := t.gs.indexer.GetQueryTerms(query, "")
+	for _, prefix := range []string{"int:", "ext:"} {
+		for _, term := range queryTerms {
+			termPrefix := []byte(prefix + term + "\x00")
+			n := 0
+			for k, _ := c.Seek(termPrefix); k != nil && bytes.HasPrefix(k, termPrefix); k, _ = c.Next() {
+				fn(k[len(termPrefix):], prefix == "int:")
+				n++
+			}
+			if trace != nil {
+				trace.scan(prefix+term, n)
+			}
+		}
+	}
+	return nil
+}
+
+// cellsLayout implements IndexLayoutCells, and IndexLayoutCompact when compact is set.
+type cellsLayout struct {
+	rc      *s2.RegionCoverer
+	compact bool
+}
+
+func (l cellsLayout) cover(entry *IndexEntry, regions []s2.Region) {
+	var interior, exterior []s2.CellID
+	for _, reg := range regions {
+		exterior = append(exterior, l.rc.Covering(reg)...)
+		interior = append(interior, l.rc.InteriorCovering(reg)...)
+	}
+	slices.Sort(interior)
+	slices.Sort(exterior)
+	entry.InteriorCells = slices.Compact(interior)
+	entry.ExteriorCells = slices.Compact(exterior)
+}
+
+func (l cellsLayout) value() []byte {
+	if l.compact {
+		return []byte{}
+	}
+	return []byte("1")
+}
+
+func (l cellsLayout) keys(tx *bolt.Tx, entry IndexEntry, assign bool) ([][]byte, error) {
+	suffix := []byte(entry.ID)
+	if l.compact {
+		seq, err := objectSeq(tx, entry.ID, assign)
+		if err != nil || seq == nil {
+			return nil, err
+		}
+		suffix = seq
+	}
+
+	keys := make([][]byte, 0, len(entry.InteriorCells)+len(entry.ExteriorCells))
+	for _, cell := range entry.InteriorCells {
+		keys = append(keys, cellKey(cell, kindInterior, suffix))
+	}
+	for _, cell := range entry.ExteriorCells {
+		keys = append(keys, cellKey(cell, kindExterior, suffix))
+	}
+	return keys, nil
+}
+
+func (l cellsLayout) scanKeys(tx *bolt.Tx, id string) ([][]byte, error) {
+	suffix := []byte(id)
+	if l.compact {
+		seq, err := objectSeq(tx, id, false)
+		if err != nil || seq == nil {
+			return nil, err
+		}
+		suffix = seq
+	}
+
+	var keys [][]byte
+	c := tx.Bucket([]byte(bucketIndex)).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if len(k) >= 9 && bytes.Equal(k[9:], suffix) {
+			keys = append(keys, bytes.Clone(k))
+		}
+	}
+	return keys, nil
+}
+
+// cellSpan is an inclusive range of cell IDs.
+type cellSpan struct {
+	lo, hi s2.CellID
+}
+
+// String describes the span with the tokens of its bounds.
+func (s cellSpan) String() string {
+	if s.lo == s.hi {
+		return s.lo.ToToken()
+	}
+	return s.lo.ToToken() + ".." + s.hi.ToToken()
+}
+
+func (l cellsLayout) candidates(tx *bolt.Tx, query s2.Region, fn func(id []byte, interior bool), trace *queryTrace) error {
+	// Compact keys are deduplicated before resolving their sequence number
+	var seqs map[uint64]bool
+	if l.compact {
+		seqs = make(map[uint64]bool)
+	}
+
+	c := tx.Bucket([]byte(bucketIndex)).Cursor()
+	for _, span := range l.querySpans(query) {
+		var lo [8]byte
+		binary.BigEndian.PutUint64(lo[:], uint64(span.lo))
+		n := 0
+		for k, _ := c.Seek(lo[:]); k != nil && len(k) >= 9; k, _ = c.Next() {
+			if s2.CellID(binary.BigEndian.Uint64(k)) > span.hi {
+				break
+			}
+			n++
+			interior := k[8] == kindInterior
+			if l.compact {
+				seq := binary.BigEndian.Uint64(k[9:])
+				seqs[seq] = seqs[seq] || interior
+				continue
+			}
+			fn(k[9:], interior)
+		}
+		if trace != nil {
+			trace.scan(span.String(), n)
+		}
+	}
+
+	if len(seqs) == 0 {
+		return nil
+	}
+	bIDs := tx.Bucket([]byte(bucketIDs))
+	if bIDs == nil {
+		return fmt.Errorf("index references sequence numbers without %s bucket", bucketIDs)
+	}
+	// Resolved in order, with a cursor moving forward through the mapping
+	sorted := slices.Sorted(maps.Keys(seqs))
+	c = bIDs.Cursor()
+	var key [8]byte
+	for _, seq := range sorted {
+		binary.BigEndian.PutUint64(key[:], seq)
+		k, id := c.Seek(key[:])
+		if !bytes.Equal(k, key[:]) {
+			return fmt.Errorf("unknown sequence number %d", seq)
+		}
+		fn(id, seqs[seq])
+	}
+	return nil
+}
+
+// querySpans returns the sorted, disjoint cell ID ranges to scan for query: the
+// range of each query cell, holding the cell and its descendants, and each
+// ancestor of the query cells down to the minimum level.
+func (l cellsLayout) querySpans(query s2.Region) []cellSpan {
+	var spans []cellSpan
+	for _, q := range l.rc.Covering(query) {
+		spans = append(spans, cellSpan{q.RangeMin(), q.RangeMax()})
+		for level := l.rc.MinLevel; level < q.Level(); level++ {
+			a := q.Parent(level)
+			spans = append(spans, cellSpan{a, a})
+		}
+	}
+	slices.SortFunc(spans, func(a, b cellSpan) int {
+		if a.lo != b.lo {
+			return cmp.Compare(a.lo, b.lo)
+		}
+		return cmp.Compare(a.hi, b.hi)
+	})
+
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.lo <= merged[n-1].hi {
+			merged[n-1].hi = max(merged[n-1].hi, s.hi)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// cellKey builds an IndexLayoutCells key: CellID (big-endian) + Kind + ID,
+// or an IndexLayoutCompact key when suffix is the sequence number.
+func cellKey(cell s2.CellID, kind byte, suffix []byte) []byte {
+	key := make([]byte, 9+len(suffix))
+	binary.BigEndian.PutUint64(key, uint64(cell))
+	key[8] = kind
+	copy(key[9:], suffix)
+	return key
+}
+
+// objectSeq returns the big-endian sequence number of the object id, nil when it
+// has none. With assign, an object without one is given the next sequence number.
+func objectSeq(tx *bolt.Tx, id string, assign bool) ([]byte, error) {
+	if bSeqs := tx.Bucket([]byte(bucketSeqs)); bSeqs != nil {
+		if seq := bSeqs.Get([]byte(id)); seq != nil {
+			return seq, nil
+		}
+	}
+	if !assign {
+		return nil, nil
+	}
+
+	bIDs, err := tx.CreateBucketIfNotExists([]byte(bucketIDs))
+	if err != nil {
+		return nil, err
+	}
+	bSeqs, err := tx.CreateBucketIfNotExists([]byte(bucketSeqs))
+	if err != nil {
+		return nil, err
+	}
+	n, err := bIDs.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	seq := binary.BigEndian.AppendUint64(nil, n)
+	if err := bIDs.Put(seq, []byte(id)); err != nil {
+		return nil, err
+	}
+	return seq, bSeqs.Put([]byte(id), seq)
+}
+
+// removeObjectSeq deletes the sequence number mapping of the object id, if any.
+func removeObjectSeq(tx *bolt.Tx, id string) error {
+	bSeqs := tx.Bucket([]byte(bucketSeqs))
+	if bSeqs == nil {
+		return nil
+	}
+	seq := bSeqs.Get([]byte(id))
+	if seq == nil {
+		return nil
+	}
+	if err := tx.Bucket([]byte(bucketIDs)).Delete(seq); err != nil {
+		return err
+	}
+	return bSeqs.Delete([]byte(id))
+}
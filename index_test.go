@@ -0,0 +1,284 @@
+package geostore
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	geom "github.com/peterstace/simplefeatures/geom"
+	bolt "go.etcd.io/bbolt"
+)
+
+// resultIDs returns the IDs of results with their distance rounded to the meter.
+func resultIDs(t testing.TB, store *GeoStore, lat, lng, radius float64) []string {
+	t.Helper()
+	results, err := store.FindClosestWithOptions(lat, lng, radius, QueryOptions{Properties: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = fmt.Sprintf("%s@%.0f", r.ID, r.Distance)
+	}
+	return ids
+}
+
+func countKeys(t testing.TB, store *GeoStore) int {
+	t.Helper()
+	n := 0
+	err := store.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte(bucketIndex)).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// indexBytes returns the size of the keys and values of the index bucket.
+func indexBytes(t testing.TB, store *GeoStore) int {
+	t.Helper()
+	n := 0
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketIndex)).ForEach(func(k, v []byte) error {
+			n += len(k) + len(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestIndexLayouts validates both layouts return the same results, and Reindex switches between them
+func TestIndexLayouts(t *testing.T) {
+	queries := []struct{ lat, lng, radius float64 }{
+		{43.55, -79.4, 5000},
+		{43.6, -79.45, 300},
+		{43.51, -79.49, 10},
+		{43.7, -79.2, 50000},
+		{45, -75, 1000},
+	}
+	line := geom.GeoJSONFeature{Geometry: makeLineString([][]float64{{-79.6, 43.4}, {-79.2, 43.8}}).AsGeometry()}
+
+	stores := map[IndexLayout]*GeoStore{}
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+		opts := DefaultOptions()
+		opts.IndexLayout = l
+		store := newTestStore(t, opts)
+		writeGrid(t, store, 200, 16)
+		entry, err := store.PrepareIndexEntry("line", line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+		if store.IndexLayout() != l {
+			t.Errorf("expected layout %s, got %s", l, store.IndexLayout())
+		}
+		stores[l] = store
+	}
+
+	terms, cells := stores[IndexLayoutTerms], stores[IndexLayoutCells]
+	if nt, nc := countKeys(t, terms), countKeys(t, cells); nc >= nt {
+		t.Errorf("expected fewer keys with cells, got %d cells vs %d terms", nc, nt)
+	}
+	if nc, nk := indexBytes(t, cells), indexBytes(t, stores[IndexLayoutCompact]); nk >= nc {
+		t.Errorf("expected a smaller index with compact, got %d bytes vs %d for cells", nk, nc)
+	}
+	for _, q := range queries {
+		want := resultIDs(t, terms, q.lat, q.lng, q.radius)
+		for _, l := range []IndexLayout{IndexLayoutCells, IndexLayoutCompact} {
+			if got := resultIDs(t, stores[l], q.lat, q.lng, q.radius); !slices.Equal(got, want) {
+				t.Errorf("%+v: %s layout returned %v, want %v", q, l, got, want)
+			}
+		}
+	}
+
+	// Overwriting drops the previous keys
+	n := countKeys(t, cells)
+	writeGrid(t, cells, 200, 16)
+	if got := countKeys(t, cells); got != n {
+		t.Errorf("expected %d keys after overwriting, got %d", n, got)
+	}
+
+	// Entries prepared for the previous layout are covered again on write
+	stale, err := terms.PrepareIndexEntry("line", line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = countKeys(t, cells)
+	if _, err := terms.Reindex(IndexLayoutCells); err != nil {
+		t.Fatal(err)
+	}
+	if err := terms.WriteBatch([]IndexEntry{stale}); err != nil {
+		t.Fatal(err)
+	}
+	if got := countKeys(t, terms); got != n {
+		t.Errorf("expected %d keys after reindexing, got %d", n, got)
+	}
+	for _, q := range queries {
+		want := resultIDs(t, cells, q.lat, q.lng, q.radius)
+		if got := resultIDs(t, terms, q.lat, q.lng, q.radius); !slices.Equal(got, want) {
+			t.Errorf("%+v: reindexed store returned %v, want %v", q, got, want)
+		}
+	}
+}
+
+// TestCompactLayoutIDs validates the sequence numbers of the compact layout across
+// a migration, overwrites and a migration back
+func TestCompactLayoutIDs(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	writeGrid(t, store, 50, 8)
+	want := resultIDs(t, store, 43.52, -79.45, 3000)
+
+	n, err := store.Reindex(IndexLayoutCompact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 50 || store.IndexLayout() != IndexLayoutCompact {
+		t.Fatalf("expected 50 objects reindexed in compact, got %d in %s", n, store.IndexLayout())
+	}
+	if got := resultIDs(t, store, 43.52, -79.45, 3000); !slices.Equal(got, want) {
+		t.Errorf("compact layout returned %v, want %v", got, want)
+	}
+
+	seqs := func() (ids, seqs int) {
+		err := store.db.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte(bucketIDs)); b != nil {
+				ids = b.Stats().KeyN
+			}
+			if b := tx.Bucket([]byte(bucketSeqs)); b != nil {
+				seqs = b.Stats().KeyN
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, seqs
+	}
+	if ids, s := seqs(); ids != 50 || s != 50 {
+		t.Errorf("expected 50 mapped IDs, got %d ids and %d seqs", ids, s)
+	}
+
+	// Overwrites keep their sequence number
+	keys := countKeys(t, store)
+	writeGrid(t, store, 50, 8)
+	if ids, s := seqs(); ids != 50 || s != 50 {
+		t.Errorf("expected 50 mapped IDs after overwriting, got %d ids and %d seqs", ids, s)
+	}
+	if got := countKeys(t, store); got != keys {
+		t.Errorf("expected %d keys after overwriting, got %d", keys, got)
+	}
+
+	if _, err := store.Reindex(IndexLayoutTerms); err != nil {
+		t.Fatal(err)
+	}
+	if ids, s := seqs(); ids != 0 || s != 0 {
+		t.Errorf("expected the ID mapping dropped, got %d ids and %d seqs", ids, s)
+	}
+	if got := resultIDs(t, store, 43.52, -79.45, 3000); !slices.Equal(got, want) {
+		t.Errorf("terms layout returned %v, want %v", got, want)
+	}
+}
+
+// TestIndexLayoutRecorded validates the layout of an existing store wins over the options
+func TestIndexLayoutRecorded(t *testing.T) {
+	opts := DefaultOptions()
+	opts.IndexLayout = IndexLayoutCells
+	store := newTestStore(t, opts)
+	writeGrid(t, store, 10, 8)
+	path := store.db.Path()
+	store.Close()
+
+	store, err := NewGeoStoreWithOptions(path, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.IndexLayout() != IndexLayoutCells {
+		t.Errorf("expected the recorded cells layout, got %s", store.IndexLayout())
+	}
+	if len(resultIDs(t, store, 43.5, -79.5, 100)) != 1 {
+		t.Error("expected a result from the reopened store")
+	}
+
+	// A store indexed before layouts were recorded keeps the terms layout
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketMeta)).Delete([]byte(metaLayout))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	opts.IndexLayout = IndexLayoutCells
+	if store, err = NewGeoStoreWithOptions(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.IndexLayout() != IndexLayoutTerms {
+		t.Errorf("expected the terms layout for an unrecorded store, got %s", store.IndexLayout())
+	}
+}
+
+func TestParseIndexLayout(t *testing.T) {
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+		got, err := ParseIndexLayout(l.String())
+		if err != nil || got != l {
+			t.Errorf("ParseIndexLayout(%q) = %v, %v", l, got, err)
+		}
+	}
+	if _, err := ParseIndexLayout("btree"); err == nil {
+		t.Error("expected an error for an unknown layout")
+	}
+}
+
+// BenchmarkIndexLayout compares the candidate lookup of the layouts, without
+// refining the candidates.
+func BenchmarkIndexLayout(b *testing.B) {
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+		opts := DefaultOptions()
+		opts.IndexLayout = l
+		store := newTestStore(b, opts)
+		writeGrid(b, store, 400, 16)
+		keys, size := countKeys(b, store), indexBytes(b, store)
+		layout, err := store.indexLayout(l)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, radius := range []float64{100, 2000, 20000} {
+			query := s2.CapFromCenterAngle(s2.PointFromLatLng(s2.LatLngFromDegrees(43.6, -79.4)), s1.Angle(radius/6371000.0))
+			b.Run(fmt.Sprintf("layout=%s/radius=%.0f", l, radius), func(b *testing.B) {
+				var candidates map[string]bool
+				err := store.db.View(func(tx *bolt.Tx) error {
+					for b.Loop() {
+						// Deduplicated like the queries do
+						candidates = make(map[string]bool)
+						err := layout.candidates(tx, query, func(id []byte, interior bool) {
+							if _, ok := candidates[string(id)]; !ok || interior {
+								candidates[string(id)] = interior
+							}
+						}, nil)
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(keys), "keys")
+				b.ReportMetric(float64(size), "index-bytes")
+				b.ReportMetric(float64(len(candidates)), "candidates")
+			})
+		}
+	}
+}
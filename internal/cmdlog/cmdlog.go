@@ -0,0 +1,48 @@
+// Package cmdlog sets up the structured logger of the commands.
+package cmdlog
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Flags holds the logging flags of a command.
+type Flags struct {
+	level *string
+	json  *bool
+}
+
+// RegisterFlags registers -log-level and -log-json on the default flag set.
+func RegisterFlags() Flags {
+	return Flags{
+		level: flag.String("log-level", "info", "Log level: debug, info, warn or error"),
+		json:  flag.Bool("log-json", false, "Write the logs as JSON lines"),
+	}
+}
+
+// Logger returns a logger writing to stderr as configured by the flags, and sets
+// it as the default logger. It exits when the level is invalid.
+func (f Flags) Logger() *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*f.level)); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -log-level: %v\n", err)
+		os.Exit(2)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if *f.json {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// Fatal logs msg at error level and exits.
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,40 @@
+package geostore
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// TestLogger validates the store traces writes and queries, and warns about skipped entries
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	store := newTestStore(t, opts)
+	writeGrid(t, store, 5, 8)
+
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketObjects)).Put([]byte("circle-0"), []byte{0, 'G', 'B', 9})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindClosest(43.5, -79.5, 1000, false); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := buf.String()
+	for _, want := range []string{
+		`level=DEBUG msg="batch written" entries=5`,
+		`level=DEBUG msg=query`,
+		`level=WARN msg="skipping undecodable entry" id=circle-0`,
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("expected %q in the logs:\n%s", want, logs)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package geostore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metrics receives measurements of the store operations, see Options.Metrics.
+// Implementations must be safe for concurrent use and should not block, they are
+// called on the query and write paths. See the prometheus package for an adapter.
+type Metrics interface {
+	// ObserveQuery is called after each query with its kind, its duration, the
+	// number of candidates matched in the index and the number of results.
+	ObserveQuery(kind QueryKind, d time.Duration, candidates, results int)
+
+	// ObserveWrite is called after each batch write, Put included, with the number
+	// of entries and the duration of the transaction, commit included. err is the
+	// write error.
+	ObserveWrite(entries int, d time.Duration, err error)
+
+	// ObserveDelete is called after each Delete with the duration of the
+	// transaction, commit included. err is the delete error, ErrNotFound when
+	// there was nothing to delete.
+	ObserveDelete(d time.Duration, err error)
+
+	// DecodeError is called when a stored entry fails to decode during a query,
+	// the entry is skipped.
+	DecodeError()
+}
+
+// QueryKind identifies the query observed by Metrics.ObserveQuery.
+type QueryKind uint8
+
+const (
+	QueryClosest    QueryKind = iota // FindClosest and EachClosest
+	QueryContaining                  // FindContaining
+	QueryRect                        // FindInRect
+)
+
+func (k QueryKind) String() string {
+	switch k {
+	case QueryClosest:
+		return "closest"
+	case QueryContaining:
+		return "containing"
+	case QueryRect:
+		return "rect"
+	}
+	return fmt.Sprintf("QueryKind(%d)", byte(k))
+}
+
+// nopMetrics is used when Options.Metrics is nil.
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveQuery(QueryKind, time.Duration, int, int) {}
+func (nopMetrics) ObserveWrite(int, time.Duration, error)          {}
+func (nopMetrics) ObserveDelete(time.Duration, error)              {}
+func (nopMetrics) DecodeError()                                    {}
@@ -0,0 +1,114 @@
+package geostore
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// recordingMetrics records the observations of a store.
+type recordingMetrics struct {
+	mu           sync.Mutex
+	queries      map[QueryKind]int
+	candidates   int
+	results      int
+	writes       []int
+	deletes      []error
+	decodeErrors int
+}
+
+func (m *recordingMetrics) ObserveQuery(kind QueryKind, d time.Duration, candidates, results int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queries == nil {
+		m.queries = make(map[QueryKind]int)
+	}
+	m.queries[kind]++
+	m.candidates += candidates
+	m.results += results
+}
+
+func (m *recordingMetrics) ObserveWrite(entries int, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes = append(m.writes, entries)
+}
+
+func (m *recordingMetrics) ObserveDelete(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deletes = append(m.deletes, err)
+}
+
+func (m *recordingMetrics) DecodeError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decodeErrors++
+}
+
+// TestMetrics validates the store reports its queries, writes and decode errors
+func TestMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	opts := DefaultOptions()
+	opts.Metrics = metrics
+	store := newTestStore(t, opts)
+	writeGrid(t, store, 20, 8)
+
+	results, err := store.FindClosest(43.5, -79.5, 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.queries[QueryClosest] != 1 || metrics.results != len(results) || metrics.candidates < len(results) {
+		t.Errorf("unexpected query metrics %+v for %d results", metrics, len(results))
+	}
+	if len(metrics.writes) != 1 || metrics.writes[0] != 20 {
+		t.Errorf("expected a write of 20 entries, got %v", metrics.writes)
+	}
+
+	// A corrupted entry is skipped and reported
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketObjects)).Put([]byte("circle-0"), []byte{0, 'G', 'B', 9})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindClosest(43.5, -79.5, 1000, false); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.decodeErrors != 1 {
+		t.Errorf("expected a decode error, got %d", metrics.decodeErrors)
+	}
+
+	// Each kind of query is reported as such
+	if _, err := store.FindContaining(43.5, -79.5, QueryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindInRect(43.4, -79.6, 43.6, -79.4, QueryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[QueryKind]int{QueryClosest: 2, QueryContaining: 1, QueryRect: 1}; !maps.Equal(metrics.queries, want) {
+		t.Errorf("expected the queries %v, got %v", want, metrics.queries)
+	}
+
+	// Put is a write of a single entry, deletes are reported with their error
+	feature := `{"type":"Feature","geometry":{"type":"Point","coordinates":[-79.4,43.6]},"properties":{}}`
+	if err := store.Put("put", []byte(feature)); err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.writes) != 2 || metrics.writes[1] != 1 {
+		t.Errorf("expected a write of 1 entry, got %v", metrics.writes)
+	}
+	if err := store.Delete("put"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("put"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if len(metrics.deletes) != 2 || metrics.deletes[0] != nil || !errors.Is(metrics.deletes[1], ErrNotFound) {
+		t.Errorf("expected a delete and a missing one, got %v", metrics.deletes)
+	}
+}
@@ -0,0 +1,76 @@
+package geostore
+
+import (
+	"bytes"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// migrateBatchSize is the number of entries upgraded per transaction.
+const migrateBatchSize = 1000
+
+// Migrate upgrades in place the entries written in an older blob version to the
+// current one, compressed with the store compression. Shapes are kept as they
+// are so the index terms don't change. Entries are upgraded in batches, each in
+// its own transaction, an interrupted migration can be run again.
+// An entry that fails to decode is reported and left as it is.
+// It returns the number of entries upgraded and the number of entries skipped.
+func (gs *GeoStore) Migrate() (migrated, skipped int, err error) {
+	opts := Options{Compression: gs.opts.Compression}
+
+	var after []byte
+	for {
+		done := false
+		err = gs.db.Update(func(tx *bolt.Tx) error {
+			bObj := tx.Bucket([]byte(bucketObjects))
+			c := bObj.Cursor()
+
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+
+			// Values can't be replaced while the cursor is iterating, collect them first
+			var keys, blobs [][]byte
+			for n := 0; k != nil && n < migrateBatchSize; k, v = c.Next() {
+				n++
+				after = append(after[:0], k...)
+				entry, err := decodeFullEntry(v)
+				if err != nil {
+					skipped++
+					gs.opts.Logger.Warn("skipping undecodable entry", "id", string(k), "error", err)
+					continue
+				}
+				if entry.header.version == blobVersionCurrent {
+					continue
+				}
+				// Shapes and properties are kept in their encoding
+				opts.Encoding = entry.shapeEncoding()
+				opts.PropertyEncoding = entry.header.properties
+				blob, err := encodeFullEntry(entry.shapes(), entry.props, entry.info, opts)
+				if err != nil {
+					return fmt.Errorf("entry %s: %w", k, err)
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				blobs = append(blobs, blob)
+			}
+			done = k == nil
+
+			for i, key := range keys {
+				if err := bObj.Put(key, blobs[i]); err != nil {
+					return err
+				}
+			}
+			migrated += len(keys)
+			gs.opts.Logger.Debug("migrated batch", "entries", len(keys), "total", migrated)
+			return nil
+		})
+		if err != nil || done {
+			return migrated, skipped, err
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package geostore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/s2"
+	geom "github.com/peterstace/simplefeatures/geom"
+	bolt "go.etcd.io/bbolt"
+)
+
+// encodeLegacyEntry encodes a blob in the unversioned layout: [PropsLen][Props][ShapeCount][Shapes...][Index]
+func encodeLegacyEntry(t *testing.T, shapes []s2.Shape, props []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.Write(binary.AppendUvarint(nil, uint64(len(props))))
+	buf.Write(props)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(shapes))))
+
+	index := s2.NewShapeIndex()
+	for _, shape := range shapes {
+		index.Add(shape)
+		var shapeBuf bytes.Buffer
+		if err := shape.(*s2.Polygon).Encode(&shapeBuf); err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteByte(typePolygon)
+		buf.Write(binary.AppendUvarint(nil, uint64(shapeBuf.Len())))
+		buf.Write(shapeBuf.Bytes())
+	}
+	index.Build()
+	if err := index.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestLegacyBlobMigration reads legacy blobs and upgrades them in place
+func TestLegacyBlobMigration(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Compression = CompressionZstd
+	store := newTestStore(t, opts)
+
+	feature := polygonFeature(
+		[][]float64{{-79.4, 43.6}, {-79.3, 43.6}, {-79.3, 43.7}, {-79.4, 43.7}, {-79.4, 43.6}},
+	)
+	feature.Properties = map[string]any{"name": "Downtown"}
+
+	// Index terms are unchanged between versions, only the blob is replaced by a legacy one
+	entry, err := store.PrepareIndexEntry("legacy", feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapes, _, err := geomToS2(feature.Geometry, &validator{policy: ValidationFix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, _ := json.Marshal(feature.Properties)
+	entry.Blob = encodeLegacyEntry(t, shapes, props)
+	if bytes.HasPrefix(entry.Blob, blobMagic) {
+		t.Fatal("legacy blob starts with the magic")
+	}
+	if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := store.PrepareIndexEntry("current", feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteBatch([]IndexEntry{current}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		results, err := store.FindClosest(43.65, -79.35, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("%s: expected 2 results, got %d", stage, len(results))
+		}
+		item, err := store.Get("legacy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Properties["name"] != "Downtown" {
+			t.Errorf("%s: unexpected properties %v", stage, item.Properties)
+		}
+		if !geom.ExactEquals(item.Geometry, feature.Geometry, geom.ToleranceXY(1e-9)) {
+			t.Errorf("%s: geometry differs: %s", stage, item.Geometry.AsText())
+		}
+	}
+	check("legacy")
+
+	n, skipped, err := store.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || skipped != 0 {
+		t.Errorf("expected 1 entry upgraded, got %d, %d skipped", n, skipped)
+	}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(bucketObjects)).Get([]byte("legacy"))
+		header, _, err := parseBlobHeader(data)
+		if header.version != blobVersionCurrent {
+			t.Errorf("expected version %d after migration, got %d", blobVersionCurrent, header.version)
+		}
+		if header.compression != CompressionZstd || header.properties != PropertiesJSON {
+			t.Errorf("unexpected header after migration: %+v", header)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("migrated")
+
+	if n, _, err := store.Migrate(); err != nil || n != 0 {
+		t.Errorf("expected nothing left to migrate, got %d, %v", n, err)
+	}
+}
+
+// TestMigrateUndecodable validates an undecodable entry is skipped and the others upgraded
+func TestMigrateUndecodable(t *testing.T) {
+	var logs bytes.Buffer
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	store := newTestStore(t, opts)
+
+	feature := polygonFeature(
+		[][]float64{{-79.4, 43.6}, {-79.3, 43.6}, {-79.3, 43.7}, {-79.4, 43.7}, {-79.4, 43.6}},
+	)
+	shapes, _, err := geomToS2(feature.Geometry, &validator{policy: ValidationFix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := encodeLegacyEntry(t, shapes, []byte(`{"name":"Downtown"}`))
+	// The objects are migrated in key order, the corrupted one sits between the others
+	blobs := map[string][]byte{"a": legacy, "b": legacy[:len(legacy)/2], "c": legacy}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketObjects))
+		for id, blob := range blobs {
+			if err := b.Put([]byte(id), blob); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, skipped, err := store.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || skipped != 1 {
+		t.Errorf("expected 2 entries upgraded and 1 skipped, got %d and %d", n, skipped)
+	}
+	if !strings.Contains(logs.String(), "id=b") {
+		t.Errorf("expected the skipped entry in the logs:\n%s", logs.String())
+	}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketObjects))
+		for _, id := range []string{"a", "c"} {
+			if header, _, err := parseBlobHeader(b.Get([]byte(id))); err != nil || header.version != blobVersionCurrent {
+				t.Errorf("%s: expected version %d after migration, got %d, %v", id, blobVersionCurrent, header.version, err)
+			}
+		}
+		if !bytes.Equal(b.Get([]byte("b")), blobs["b"]) {
+			t.Error("expected the undecodable entry left as it is")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
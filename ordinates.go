@@ -0,0 +1,200 @@
+package geostore
+
+import (
+	"github.com/golang/geo/s2"
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// ordinates is the side channel for Z and M values, which S2 shapes can't hold.
+// Values are stored by vertex index, Z then M as present, in the order vertices
+// are stored in the shapes: points and lines in order, polygons loop by loop in
+// hierarchy order. Rebuilding the geometry consumes them in that same order.
+type ordinates struct {
+	ctype  geom.CoordinatesType
+	values []float64
+	pos    int
+}
+
+// collectOrdinates returns the Z/M values of g for the vertices of its shapes,
+// or nil if g is XY. Shapes may have been reordered or repaired during conversion:
+// each chain of vertices of the shapes (a line, a loop, the points of a shape) is
+// aligned with the chain of g it was built from, so a position repeated along a
+// chain keeps the values of each of its occurrences. snap maps original positions
+// to the positions in shapes.
+func collectOrdinates(g geom.Geometry, shapes []s2.Shape, snap func(s2.Point) s2.Point) *ordinates {
+	ctype := g.CoordinatesType()
+	if ctype == geom.DimXY {
+		return nil
+	}
+
+	stride := ctype.Dimension() - 2
+	var chains []zmChain
+	appendChains(&chains, g, snap)
+	// Chains by position, in the order of g, to find the chain of each shape chain
+	byPoint := make(map[s2.Point][]int)
+	for i, c := range chains {
+		for _, pt := range c.pts {
+			if ids := byPoint[pt]; len(ids) == 0 || ids[len(ids)-1] != i {
+				byPoint[pt] = append(ids, i)
+			}
+		}
+	}
+
+	o := &ordinates{ctype: ctype}
+	used := make([]bool, len(chains))
+	forEachChain(shapes, func(pts []s2.Point) {
+		if len(pts) == 0 {
+			return
+		}
+		for _, i := range byPoint[pts[0]] {
+			if used[i] {
+				continue
+			}
+			if values, ok := chains[i].align(pts, stride); ok {
+				used[i] = true
+				o.values = append(o.values, values...)
+				return
+			}
+		}
+		// Not found in g, e.g. a vertex moved by a repair
+		o.values = append(o.values, make([]float64, len(pts)*stride)...)
+	})
+	return o
+}
+
+// zmChain is a chain of vertices of the input geometry with their Z/M values:
+// the points of a point or a multipoint, a line, or a ring without its closing vertex.
+type zmChain struct {
+	pts    []s2.Point
+	values []float64 // Stride values per vertex
+}
+
+// appendChains appends the chains of g, in order.
+func appendChains(chains *[]zmChain, g geom.Geometry, snap func(s2.Point) s2.Point) {
+	switch g.Type() {
+	case geom.TypePoint, geom.TypeMultiPoint:
+		*chains = append(*chains, newZMChain(g.DumpCoordinates(), snap))
+	case geom.TypeLineString:
+		*chains = append(*chains, newZMChain(g.MustAsLineString().Coordinates(), snap))
+	case geom.TypeMultiLineString:
+		ml := g.MustAsMultiLineString()
+		for i := range ml.NumLineStrings() {
+			*chains = append(*chains, newZMChain(ml.LineStringN(i).Coordinates(), snap))
+		}
+	case geom.TypePolygon:
+		appendRings(chains, g.MustAsPolygon(), snap)
+	case geom.TypeMultiPolygon:
+		mp := g.MustAsMultiPolygon()
+		for i := range mp.NumPolygons() {
+			appendRings(chains, mp.PolygonN(i), snap)
+		}
+	case geom.TypeGeometryCollection:
+		gc := g.MustAsGeometryCollection()
+		for i := range gc.NumGeometries() {
+			appendChains(chains, gc.GeometryN(i), snap)
+		}
+	}
+}
+
+func appendRings(chains *[]zmChain, poly geom.Polygon, snap func(s2.Point) s2.Point) {
+	for _, ring := range poly.DumpRings() {
+		seq := ring.Coordinates()
+		// The closing vertex isn't a vertex of the loop
+		if n := seq.Length(); n > 0 && seq.GetXY(0) == seq.GetXY(n-1) {
+			seq = seq.Slice(0, n-1)
+		}
+		*chains = append(*chains, newZMChain(seq, snap))
+	}
+}
+
+func newZMChain(seq geom.Sequence, snap func(s2.Point) s2.Point) zmChain {
+	ctype := seq.CoordinatesType()
+	c := zmChain{pts: make([]s2.Point, seq.Length())}
+	for i := range c.pts {
+		pt := seq.Get(i)
+		c.pts[i] = snap(s2.PointFromLatLng(s2.LatLngFromDegrees(pt.Y, pt.X)))
+		if ctype.Is3D() {
+			c.values = append(c.values, pt.Z)
+		}
+		if ctype.IsMeasured() {
+			c.values = append(c.values, pt.M)
+		}
+	}
+	return c
+}
+
+// align returns the Z/M values of pts, a chain of the shapes built from c: the
+// vertices of c in order or in reverse order for an inverted loop, less the
+// duplicates removed by the validation.
+func (c zmChain) align(pts []s2.Point, stride int) ([]float64, bool) {
+	for _, reverse := range []bool{false, true} {
+		values := make([]float64, 0, len(pts)*stride)
+		j := 0
+		for _, pt := range pts {
+			for j < len(c.pts) && c.pts[c.index(j, reverse)] != pt {
+				j++
+			}
+			if j == len(c.pts) {
+				break
+			}
+			k := c.index(j, reverse) * stride
+			values = append(values, c.values[k:k+stride]...)
+			j++
+		}
+		if len(values) == len(pts)*stride {
+			return values, true
+		}
+	}
+	return nil, false
+}
+
+func (c zmChain) index(j int, reverse bool) int {
+	if reverse {
+		return len(c.pts) - 1 - j
+	}
+	return j
+}
+
+// forEachChain calls fn with every chain of vertices of shapes in storage order:
+// the points of a point vector, a line, the loops of a polygon.
+func forEachChain(shapes []s2.Shape, fn func([]s2.Point)) {
+	for _, s := range shapes {
+		switch v := s.(type) {
+		case *s2.PointVector:
+			fn(*v)
+		case *s2.Polyline:
+			fn(*v)
+		case *s2.Polygon:
+			for i := 0; i < v.NumLoops(); i++ {
+				fn(v.Loop(i).Vertices())
+			}
+		}
+	}
+}
+
+// coordinatesType returns the coordinates type of rebuilt geometries, XY without side channel.
+func (o *ordinates) coordinatesType() geom.CoordinatesType {
+	if o == nil {
+		return geom.DimXY
+	}
+	return o.ctype
+}
+
+// appendVertex appends the coordinates of pt followed by its next Z/M values.
+func (o *ordinates) appendVertex(coords []float64, pt s2.Point) []float64 {
+	ll := s2.LatLngFromPoint(pt)
+	coords = append(coords, ll.Lng.Degrees(), ll.Lat.Degrees())
+	if o == nil {
+		return coords
+	}
+	stride := o.ctype.Dimension() - 2
+	for i := range stride {
+		var v float64
+		if o.pos+i < len(o.values) {
+			v = o.values[o.pos+i]
+		}
+		coords = append(coords, v)
+	}
+	o.pos += stride
+	return coords
+}
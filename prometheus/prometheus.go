@@ -0,0 +1,126 @@
+// Package prometheus exports the metrics of a GeoStore to Prometheus.
+//
+//	metrics, err := prometheus.New(prom.DefaultRegisterer, "geostore")
+//	opts := geostore.DefaultOptions()
+//	opts.Metrics = metrics
+package prometheus
+
+import (
+	"errors"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements geostore.Metrics with Prometheus collectors.
+// The query collectors are labelled by the kind of query.
+type Metrics struct {
+	queryDuration   *prom.HistogramVec
+	queryCandidates *prom.HistogramVec
+	queryResults    *prom.HistogramVec
+	writeEntries    prom.Histogram
+	writeDuration   prom.Histogram
+	writeErrors     prom.Counter
+	deleteDuration  prom.Histogram
+	deleteErrors    prom.Counter
+	decodeErrors    prom.Counter
+}
+
+var _ geostore.Metrics = (*Metrics)(nil)
+
+// New creates the collectors, with names prefixed by namespace, and registers them with reg.
+func New(reg prom.Registerer, namespace string) (*Metrics, error) {
+	counts := prom.ExponentialBuckets(1, 4, 10) // 1 to 262144
+	m := &Metrics{
+		queryDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of the queries, by kind: closest, containing or rect.",
+			Buckets:   prom.ExponentialBuckets(0.0001, 4, 10), // 100µs to 26s
+		}, []string{"kind"}),
+		queryCandidates: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_candidates",
+			Help:      "Candidates matched in the index per query, by kind.",
+			Buckets:   counts,
+		}, []string{"kind"}),
+		queryResults: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_results",
+			Help:      "Results returned per query, by kind.",
+			Buckets:   counts,
+		}, []string{"kind"}),
+		writeEntries: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "write_batch_entries",
+			Help:      "Entries per write batch.",
+			Buckets:   counts,
+		}),
+		writeDuration: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "write_duration_seconds",
+			Help:      "Duration of the write transactions, commit included.",
+			Buckets:   prom.ExponentialBuckets(0.001, 4, 10), // 1ms to 262s
+		}),
+		writeErrors: prom.NewCounter(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "write_errors_total",
+			Help:      "Write batches that failed.",
+		}),
+		deleteDuration: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delete_duration_seconds",
+			Help:      "Duration of the delete transactions, commit included.",
+			Buckets:   prom.ExponentialBuckets(0.001, 4, 10), // 1ms to 262s
+		}),
+		deleteErrors: prom.NewCounter(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "delete_errors_total",
+			Help:      "Deletes that failed, deletes of missing objects excluded.",
+		}),
+		decodeErrors: prom.NewCounter(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "decode_errors_total",
+			Help:      "Stored entries that failed to decode during queries.",
+		}),
+	}
+
+	for _, c := range []prom.Collector{
+		m.queryDuration, m.queryCandidates, m.queryResults,
+		m.writeEntries, m.writeDuration, m.writeErrors,
+		m.deleteDuration, m.deleteErrors, m.decodeErrors,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) ObserveQuery(kind geostore.QueryKind, d time.Duration, candidates, results int) {
+	m.queryDuration.WithLabelValues(kind.String()).Observe(d.Seconds())
+	m.queryCandidates.WithLabelValues(kind.String()).Observe(float64(candidates))
+	m.queryResults.WithLabelValues(kind.String()).Observe(float64(results))
+}
+
+func (m *Metrics) ObserveWrite(entries int, d time.Duration, err error) {
+	if err != nil {
+		m.writeErrors.Inc()
+		return
+	}
+	m.writeEntries.Observe(float64(entries))
+	m.writeDuration.Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveDelete(d time.Duration, err error) {
+	if err != nil && !errors.Is(err, geostore.ErrNotFound) {
+		m.deleteErrors.Inc()
+		return
+	}
+	m.deleteDuration.Observe(d.Seconds())
+}
+
+func (m *Metrics) DecodeError() {
+	m.decodeErrors.Inc()
+}
@@ -0,0 +1,53 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	geostore "github.com/akhenakh/geobbolt"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prom.NewPedanticRegistry()
+	m, err := New(reg, "geo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ObserveQuery(geostore.QueryClosest, 3*time.Millisecond, 12, 4)
+	m.ObserveQuery(geostore.QueryClosest, time.Millisecond, 0, 0)
+	m.ObserveQuery(geostore.QueryRect, time.Millisecond, 3, 3)
+	m.ObserveWrite(500, 40*time.Millisecond, nil)
+	m.ObserveWrite(500, time.Millisecond, errors.New("disk full"))
+	m.ObserveDelete(2*time.Millisecond, nil)
+	m.ObserveDelete(time.Millisecond, geostore.ErrNotFound)
+	m.ObserveDelete(time.Millisecond, errors.New("disk full"))
+	m.DecodeError()
+
+	// A histogram per kind of query
+	if n := testutil.CollectAndCount(reg, "geo_query_duration_seconds"); n != 2 {
+		t.Errorf("expected the closest and rect query histograms, got %d", n)
+	}
+	if got := testutil.ToFloat64(m.writeErrors); got != 1 {
+		t.Errorf("expected 1 write error, got %v", got)
+	}
+	// A missing object is not an error of the store
+	if got := testutil.ToFloat64(m.deleteErrors); got != 1 {
+		t.Errorf("expected 1 delete error, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.decodeErrors); got != 1 {
+		t.Errorf("expected 1 decode error, got %v", got)
+	}
+	// The pedantic registry checks the collectors are consistent
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Registering twice fails
+	if _, err := New(reg, "geo"); err == nil {
+		t.Error("expected a duplicate registration error")
+	}
+}
@@ -0,0 +1,262 @@
+package geostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// PropertyEncoding selects how feature properties are encoded in the blobs.
+type PropertyEncoding byte
+
+const (
+	// PropertiesJSON stores properties as JSON, returned untouched as raw properties.
+	PropertiesJSON PropertyEncoding = 0
+
+	// PropertiesCBOR stores properties as CBOR, more compact and cheaper to decode.
+	// Integers, floats, booleans, strings, nulls and nested values keep their types.
+	PropertiesCBOR PropertyEncoding = 1
+)
+
+func (e PropertyEncoding) String() string {
+	switch e {
+	case PropertiesJSON:
+		return "json"
+	case PropertiesCBOR:
+		return "cbor"
+	}
+	return fmt.Sprintf("PropertyEncoding(%d)", byte(e))
+}
+
+// ParsePropertyEncoding parses "json" or "cbor".
+func ParsePropertyEncoding(s string) (PropertyEncoding, error) {
+	for _, e := range []PropertyEncoding{PropertiesJSON, PropertiesCBOR} {
+		if e.String() == s {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown property encoding %q (want json or cbor)", s)
+}
+
+var (
+	cborEnc, _ = cbor.EncOptions{ShortestFloat: cbor.ShortestFloat16}.EncMode()
+	cborDec, _ = cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+		IntDec:         cbor.IntDecConvertSignedOrBigInt,
+	}.DecMode()
+)
+
+// marshalProperties encodes properties with e.
+func marshalProperties(props map[string]any, e PropertyEncoding) ([]byte, error) {
+	switch e {
+	case PropertiesJSON:
+		return json.Marshal(props)
+	case PropertiesCBOR:
+		return cborEnc.Marshal(props)
+	}
+	return nil, fmt.Errorf("unknown property encoding %d", byte(e))
+}
+
+// unmarshalProperties decodes properties encoded with e.
+func unmarshalProperties(data []byte, e PropertyEncoding) (map[string]any, error) {
+	var props map[string]any
+	if err := unmarshalPropertiesInto(data, e, &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// unmarshalPropertiesInto decodes properties encoded with e into v, a map or a struct pointer.
+func unmarshalPropertiesInto(data []byte, e PropertyEncoding, v any) error {
+	switch e {
+	case PropertiesJSON:
+		return json.Unmarshal(data, v)
+	case PropertiesCBOR:
+		return cborDec.Unmarshal(data, v)
+	}
+	return fmt.Errorf("unknown property encoding %d", byte(e))
+}
+
+// QueryOptions controls what queries return for each result.
+type QueryOptions struct {
+	// WithGeometry rebuilds the geometry of the results.
+	WithGeometry bool
+
+	// Properties lists the property keys to return. Nil returns all the properties,
+	// an empty non nil slice returns none and skips decoding them entirely.
+	Properties []string
+
+	// RawProperties returns the properties as JSON in StoredItem.RawProperties,
+	// e.g. to pass them through to an HTTP response. JSON properties are returned
+	// without being decoded.
+	RawProperties bool
+
+	// Explain, when set, is filled with how the query ran: the index lookups,
+	// the candidates and where time was spent. Measuring adds some overhead.
+	Explain *Explain
+
+	// Context, when set, stops the query once it is done: the candidates left are
+	// not refined and the query returns the context error.
+	Context context.Context
+
+	// decodeInto, when set, is given the stored properties of each result instead
+	// of decoding them in StoredItem, see FindClosestAs. It may be called concurrently.
+	decodeInto func(id string, data []byte, e PropertyEncoding) error
+
+	// trace collects Explain, set by the query.
+	trace *queryTrace
+}
+
+// done returns the error of the query context once it is done.
+func (o QueryOptions) done() error {
+	if o.Context == nil {
+		return nil
+	}
+	return o.Context.Err()
+}
+
+// noProperties returns true when the options don't ask for any property.
+func (o QueryOptions) noProperties() bool {
+	return o.Properties != nil && len(o.Properties) == 0
+}
+
+// decodeProperties decodes the stored properties as requested by opts,
+// either into a map or as raw JSON. Only the projected values are decoded.
+func decodeProperties(data []byte, e PropertyEncoding, opts QueryOptions) (map[string]any, json.RawMessage, error) {
+	if opts.noProperties() {
+		return nil, nil, nil
+	}
+	if e == PropertiesJSON {
+		return decodeJSONProperties(data, opts)
+	}
+
+	var props map[string]any
+	if opts.Properties == nil {
+		var err error
+		if props, err = unmarshalProperties(data, e); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		// Projection, values are split and only the projected ones decoded
+		var fields map[string]cbor.RawMessage
+		if err := cborDec.Unmarshal(data, &fields); err != nil {
+			return nil, nil, err
+		}
+		props = make(map[string]any, len(opts.Properties))
+		for _, key := range opts.Properties {
+			v, ok := fields[key]
+			if !ok {
+				continue
+			}
+			var value any
+			if err := cborDec.Unmarshal(v, &value); err != nil {
+				return nil, nil, err
+			}
+			props[key] = value
+		}
+	}
+
+	if opts.RawProperties {
+		raw, err := json.Marshal(props)
+		return nil, raw, err
+	}
+	return props, nil, nil
+}
+
+func decodeJSONProperties(data []byte, opts QueryOptions) (map[string]any, json.RawMessage, error) {
+	if opts.Properties == nil {
+		if opts.RawProperties {
+			// Copied, data may be shared with the entry cache
+			return nil, bytes.Clone(data), nil
+		}
+		var props map[string]any
+		if err := json.Unmarshal(data, &props); err != nil {
+			return nil, nil, err
+		}
+		return props, nil, nil
+	}
+
+	// Projection, values are split but left undecoded
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+	projected := make(map[string]json.RawMessage, len(opts.Properties))
+	for _, key := range opts.Properties {
+		if v, ok := fields[key]; ok {
+			projected[key] = v
+		}
+	}
+
+	if opts.RawProperties {
+		raw, err := json.Marshal(projected)
+		return nil, raw, err
+	}
+	props := make(map[string]any, len(projected))
+	for key, v := range projected {
+		var value any
+		if err := json.Unmarshal(v, &value); err != nil {
+			return nil, nil, err
+		}
+		props[key] = value
+	}
+	return props, nil, nil
+}
+
+// ParseFeature parses a GeoJSON feature like json.Unmarshal, except that integer
+// property values are kept as int64 instead of float64, so they round-trip
+// through PropertiesCBOR as integers.
+func ParseFeature(data []byte) (geom.GeoJSONFeature, error) {
+	var feature geom.GeoJSONFeature
+	if err := json.Unmarshal(data, &feature); err != nil {
+		return feature, err
+	}
+
+	var raw struct {
+		Properties json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return feature, err
+	}
+	if len(raw.Properties) == 0 {
+		return feature, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw.Properties))
+	dec.UseNumber()
+	var props map[string]any
+	if err := dec.Decode(&props); err != nil {
+		return feature, err
+	}
+	for k, v := range props {
+		props[k] = convertNumbers(v)
+	}
+	feature.Properties = props
+	return feature, nil
+}
+
+// convertNumbers replaces the json.Number values in v by int64, or float64 when
+// they have a fraction, an exponent or don't fit.
+func convertNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = convertNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = convertNumbers(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,153 @@
+package geostore
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestQueryProjection validates property projection and raw properties in query results
+func TestQueryProjection(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	feature := polygonFeature([][]float64{
+		{-79.4, 43.6}, {-79.3, 43.6}, {-79.3, 43.7}, {-79.4, 43.7}, {-79.4, 43.6},
+	})
+	feature.Properties = map[string]any{"name": "Downtown", "pop": 1234.0, "tags": []any{"a", "b"}}
+	entry, err := store.PrepareIndexEntry("downtown", feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(opts QueryOptions) StoredItem {
+		t.Helper()
+		results, err := store.FindClosestWithOptions(43.65, -79.35, 10, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		return results[0]
+	}
+
+	tests := []struct {
+		name     string
+		opts     QueryOptions
+		wantKeys []string
+	}{
+		{"all", QueryOptions{}, []string{"name", "pop", "tags"}},
+		{"projection", QueryOptions{Properties: []string{"name", "missing"}}, []string{"name"}},
+		{"none", QueryOptions{Properties: []string{}}, nil},
+		{"raw", QueryOptions{RawProperties: true}, []string{"name", "pop", "tags"}},
+		{"raw projection", QueryOptions{RawProperties: true, Properties: []string{"pop"}}, []string{"pop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := query(tt.opts)
+			props := item.Properties
+			if tt.opts.RawProperties {
+				if props != nil {
+					t.Errorf("expected no decoded properties, got %v", props)
+				}
+				if err := json.Unmarshal(item.RawProperties, &props); err != nil {
+					t.Fatalf("invalid raw properties %q: %v", item.RawProperties, err)
+				}
+			} else if item.RawProperties != nil {
+				t.Errorf("unexpected raw properties %s", item.RawProperties)
+			}
+			if len(props) != len(tt.wantKeys) {
+				t.Errorf("expected keys %v, got %v", tt.wantKeys, props)
+			}
+			for _, k := range tt.wantKeys {
+				if _, ok := props[k]; !ok {
+					t.Errorf("missing key %q in %v", k, props)
+				}
+			}
+			if props != nil && props["pop"] != nil && props["pop"] != 1234.0 {
+				t.Errorf("unexpected pop value %v", props["pop"])
+			}
+		})
+	}
+}
+
+// TestPropertyEncodings validates typed values round-trip through every property encoding
+func TestPropertyEncodings(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	raw := []byte(`{"type": "Feature",
+		"geometry": {"type": "Polygon", "coordinates": [[[-79.4, 43.6], [-79.3, 43.6], [-79.3, 43.7], [-79.4, 43.7], [-79.4, 43.6]]]},
+		"properties": {"name": "Downtown", "pop": 1234, "area": 12.5, "big": 1e30, "open": true, "closed": null,
+			"nested": {"floors": [1, 2.5, "roof"]}}}`)
+
+	encodings := []PropertyEncoding{PropertiesJSON, PropertiesCBOR}
+	for _, e := range encodings {
+		store.opts.PropertyEncoding = e
+		if err := store.Put(e.String(), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, e := range encodings {
+		item, err := store.Get(e.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		props := item.Properties
+		if e == PropertiesCBOR {
+			// Only the binary encoding keeps integer types
+			if props["pop"] != int64(1234) {
+				t.Errorf("%s: pop = %#v, want int64", e, props["pop"])
+			}
+			floors := props["nested"].(map[string]any)["floors"].([]any)
+			if floors[0] != int64(1) || floors[1] != 2.5 || floors[2] != "roof" {
+				t.Errorf("%s: unexpected nested values %#v", e, floors)
+			}
+		} else if props["pop"] != 1234.0 {
+			t.Errorf("%s: pop = %#v", e, props["pop"])
+		}
+		if props["name"] != "Downtown" || props["area"] != 12.5 || props["big"] != 1e30 ||
+			props["open"] != true || props["closed"] != nil {
+			t.Errorf("%s: unexpected properties %#v", e, props)
+		}
+		if _, ok := props["closed"]; !ok {
+			t.Errorf("%s: null property dropped", e)
+		}
+	}
+
+	// Projection and raw JSON work whatever the encoding
+	results, err := store.FindClosestWithOptions(43.65, -79.35, 10, QueryOptions{RawProperties: true, Properties: []string{"pop", "open"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(encodings) {
+		t.Fatalf("expected %d results, got %d", len(encodings), len(results))
+	}
+	for _, r := range results {
+		if string(r.RawProperties) != `{"open":true,"pop":1234}` {
+			t.Errorf("%s: unexpected raw properties %s", r.ID, r.RawProperties)
+		}
+	}
+}
+
+// BenchmarkPropertyDecoding reports the size and the decoding cost of each property encoding
+func BenchmarkPropertyDecoding(b *testing.B) {
+	props := map[string]any{
+		"name": "High Park", "kind": "park", "area": 161.87, "visitors": int64(1500000),
+		"open": true, "amenities": []any{"zoo", "pool", "playground"}, "address": map[string]any{"city": "Toronto", "number": int64(1873)},
+	}
+	for _, e := range []PropertyEncoding{PropertiesJSON, PropertiesCBOR} {
+		b.Run(e.String(), func(b *testing.B) {
+			data, err := marshalProperties(props, e)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for range b.N {
+				if _, err := unmarshalProperties(data, e); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+	}
+}
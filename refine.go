@@ -0,0 +1,210 @@
+package geostore
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// refinement is the outcome of decodedEntry.distance.
+type refinement byte
+
+const (
+	refineMatch            refinement = iota
+	refineCulledByIndex               // No cell of the per-object index within the limit
+	refineCulledByDistance            // Cells within the limit, edges farther
+)
+
+// refineFunc refines a candidate of a query, returning its distance to the query
+// region when it matches. err reports a corrupted per-object index.
+type refineFunc func(e *decodedEntry) (s1.ChordAngle, refinement, error)
+
+// nearCoverer covers the query around a point to look up the cells of the per-object index.
+var nearCoverer = &s2.RegionCoverer{MaxLevel: s2.MaxLevel, MaxCells: 8}
+
+// nearCell is a cell of the per-object index near a query point.
+type nearCell struct {
+	pos  int           // Position in the index
+	dist s1.ChordAngle // Distance to the cell, a lower bound of the distance to its edges
+}
+
+// distance returns the distance from p to the shapes of the entry, 0 when a polygon
+// contains p, or why the shapes are farther than limit. It works from the cells of
+// the encoded index of the entry: only the cells within limit are decoded, nearest
+// first, and only the edges clipped to them are measured, so the cost depends on
+// the edges near p rather than on the size of the shapes.
+func (e *decodedEntry) distance(p s2.Point, limit s1.ChordAngle) (s1.ChordAngle, refinement, error) {
+	// Containment: only the polygons clipped to the index cell holding p can contain it
+	if i, ok := e.index.locate(p); ok {
+		contains, err := e.cellContains(i, p)
+		if err != nil {
+			return 0, refineCulledByIndex, err
+		}
+		if contains {
+			return 0, refineMatch, nil
+		}
+	}
+
+	cells := e.nearCells(p, limit)
+	if len(cells) == 0 {
+		return 0, refineCulledByIndex, nil
+	}
+
+	best, _, err := e.closestEdge(p, cells)
+	if err != nil {
+		return 0, refineCulledByIndex, err
+	}
+	if best > limit {
+		return best, refineCulledByDistance, nil
+	}
+	return best, refineMatch, nil
+}
+
+// nearCells returns the cells of the index within limit of p.
+func (e *decodedEntry) nearCells(p s2.Point, limit s1.ChordAngle) []nearCell {
+	var cells []nearCell
+	covering := nearCoverer.Covering(s2.CapFromCenterChordAngle(p, limit))
+	e.index.intersecting(covering, func(i int) bool {
+		if d := s2.CellFromCellID(e.index.cellID(i)).Distance(p); d <= limit {
+			cells = append(cells, nearCell{pos: i, dist: d})
+		}
+		return true
+	})
+	return cells
+}
+
+// closestEdge returns the distance from p to the closest edge clipped to cells, and
+// the number of edges measured. Cells are visited by increasing distance, up to the
+// first one farther than the closest edge found: the closest point of the shapes
+// lies in a cell at most that far.
+func (e *decodedEntry) closestEdge(p s2.Point, cells []nearCell) (best s1.ChordAngle, measured int, err error) {
+	slices.SortFunc(cells, func(a, b nearCell) int { return cmp.Compare(a.dist, b.dist) })
+	best = s1.InfChordAngle()
+	for _, c := range cells {
+		if c.dist > best {
+			break
+		}
+		clipped, err := e.index.cell(c.pos)
+		if err != nil {
+			return best, measured, err
+		}
+		for _, cs := range clipped {
+			shape := e.factory.GetShape(int(cs.shape))
+			if shape == nil {
+				continue
+			}
+			for _, id := range cs.edges {
+				if id >= shape.NumEdges() {
+					return best, measured, fmt.Errorf("edge %d of shape %d out of %d", id, cs.shape, shape.NumEdges())
+				}
+				edge := shape.Edge(id)
+				best, _ = s2.UpdateMinDistance(p, edge.V0, edge.V1, best)
+				measured++
+			}
+		}
+	}
+	return best, measured, nil
+}
+
+// cellContains reports whether a polygon contains p, in the i-th cell of the index
+// which holds p: from whether the polygon contains the cell center, counting the
+// crossings of its edges clipped to the cell by the segment from the center to p.
+func (e *decodedEntry) cellContains(i int, p s2.Point) (bool, error) {
+	clipped, err := e.index.cell(i)
+	if err != nil {
+		return false, err
+	}
+	center := e.index.cellID(i).Point()
+	for _, cs := range clipped {
+		shape := e.factory.GetShape(int(cs.shape))
+		if shape == nil || shape.Dimension() != 2 {
+			continue
+		}
+		inside := cs.containsCenter
+		crosser := s2.NewEdgeCrosser(center, p)
+		for _, id := range cs.edges {
+			if id >= shape.NumEdges() {
+				return false, fmt.Errorf("edge %d of shape %d out of %d", id, cs.shape, shape.NumEdges())
+			}
+			edge := shape.Edge(id)
+			inside = inside != crosser.EdgeOrVertexCrossing(edge.V0, edge.V1)
+		}
+		if inside {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// intersectsRect reports whether the shapes of the entry intersect rect, or why
+// they don't. The edges of rect are taken as geodesics between its vertices,
+// exact along meridians and close enough along parallels for small rectangles.
+// Only the edges clipped to the cells of the per-object index intersecting rect are tested.
+func (e *decodedEntry) intersectsRect(rect s2.Rect) (refinement, error) {
+	var corners [4]s2.Point
+	for i := range corners {
+		corners[i] = s2.PointFromLatLng(rect.Vertex(i))
+	}
+
+	r := refineCulledByIndex
+	var err error
+	e.index.intersecting(nearCoverer.Covering(rect), func(i int) bool {
+		if !rect.IntersectsCell(s2.CellFromCellID(e.index.cellID(i))) {
+			return true
+		}
+		r = refineCulledByDistance
+		var crosses bool
+		if crosses, err = e.cellCrossesRect(i, rect, corners); crosses {
+			r = refineMatch
+		}
+		return err == nil && !crosses
+	})
+	if err != nil || r != refineCulledByDistance {
+		return r, err
+	}
+
+	// No edge inside nor crossing, the rectangle may be inside a polygon
+	if i, ok := e.index.locate(corners[0]); ok {
+		contains, err := e.cellContains(i, corners[0])
+		if err != nil {
+			return refineCulledByIndex, err
+		}
+		if contains {
+			return refineMatch, nil
+		}
+	}
+	return refineCulledByDistance, nil
+}
+
+// cellCrossesRect reports whether an edge clipped to the i-th cell of the index has
+// a vertex inside rect or crosses its boundary.
+func (e *decodedEntry) cellCrossesRect(i int, rect s2.Rect, corners [4]s2.Point) (bool, error) {
+	clipped, err := e.index.cell(i)
+	if err != nil {
+		return false, err
+	}
+	for _, cs := range clipped {
+		shape := e.factory.GetShape(int(cs.shape))
+		if shape == nil {
+			continue
+		}
+		for _, id := range cs.edges {
+			if id >= shape.NumEdges() {
+				return false, fmt.Errorf("edge %d of shape %d out of %d", id, cs.shape, shape.NumEdges())
+			}
+			edge := shape.Edge(id)
+			if rect.ContainsPoint(edge.V0) || rect.ContainsPoint(edge.V1) {
+				return true, nil
+			}
+			for j := range corners {
+				if s2.CrossingSign(corners[j], corners[(j+1)%4], edge.V0, edge.V1) != s2.DoNotCross {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,383 @@
+package geostore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// TestRefineDistance validates distances computed from the per-object index, with and without the cache
+func TestRefineDistance(t *testing.T) {
+	feature := polygonFeature([][]float64{
+		{-79.4, 43.6}, {-79.3, 43.6}, {-79.3, 43.7}, {-79.4, 43.7}, {-79.4, 43.6},
+	})
+	line := geom.GeoJSONFeature{Geometry: makeLineString([][]float64{{-79.28, 43.6}, {-79.28, 43.7}}).AsGeometry()}
+
+	tests := []struct {
+		name     string
+		lat, lng float64
+		radius   float64
+		want     map[string]float64 // Expected distances by ID, within 1m
+	}{
+		// Inside close to the boundary, the cap only overlaps exterior cells
+		{"inside near edge", 43.65, -79.30005, 1000, map[string]float64{"square": 0}},
+		{"deep inside", 43.65, -79.35, 10, map[string]float64{"square": 0}},
+		// 0.01 degree of longitude at 43.65 is about 805m
+		{"outside", 43.65, -79.29, 1000, map[string]float64{"square": 805, "line": 805}},
+		{"out of range", 43.65, -79.25, 1000, map[string]float64{}},
+	}
+
+	for _, cacheSize := range []int{0, 8} {
+		opts := DefaultOptions()
+		opts.CacheSize = cacheSize
+		store := newTestStore(t, opts)
+		for id, f := range map[string]geom.GeoJSONFeature{"square": feature, "line": line} {
+			entry, err := store.PrepareIndexEntry(id, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tt := range tests {
+			// Twice to go through the cached entries
+			for range 2 {
+				results, err := store.FindClosest(tt.lat, tt.lng, tt.radius, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(results) != len(tt.want) {
+					t.Errorf("cache=%d %s: expected %d results, got %+v", cacheSize, tt.name, len(tt.want), results)
+					continue
+				}
+				for _, r := range results {
+					if want, ok := tt.want[r.ID]; !ok || math.Abs(r.Distance-want) > 1 {
+						t.Errorf("cache=%d %s: %s at %.2fm, want %.2fm", cacheSize, tt.name, r.ID, r.Distance, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// decodeFeature prepares and decodes the entry of a feature
+func decodeFeature(t testing.TB, store *GeoStore, g geom.Geometry) *decodedEntry {
+	t.Helper()
+	entry, err := store.PrepareIndexEntry("f", geom.GeoJSONFeature{Geometry: g})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := decodeFullEntry(entry.Blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// bruteDistance measures the distance from p to every edge of the entry, 0 inside a polygon
+func bruteDistance(e *decodedEntry, p s2.Point) s1.ChordAngle {
+	best := s1.InfChordAngle()
+	for _, shape := range e.shapes() {
+		if poly, ok := shape.(*s2.Polygon); ok && poly.ContainsPoint(p) {
+			return 0
+		}
+		for i := range shape.NumEdges() {
+			edge := shape.Edge(i)
+			best, _ = s2.UpdateMinDistance(p, edge.V0, edge.V1, best)
+		}
+	}
+	return best
+}
+
+// TestRefineIndexCells validates the refinement from the index cells against every edge of the shapes
+func TestRefineIndexCells(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	// A polygon with a hole, a smaller polygon, a line and a point
+	g := geom.NewGeometryCollection([]geom.Geometry{
+		geom.NewPolygon([]geom.LineString{
+			circleRing(-79.38, 43.65, 0.05, 500, false),
+			circleRing(-79.38, 43.65, 0.01, 100, true),
+		}).AsGeometry(),
+		geom.NewPolygon([]geom.LineString{circleRing(-79.25, 43.65, 0.02, 50, false)}).AsGeometry(),
+		makeLineString([][]float64{{-79.5, 43.55}, {-79.3, 43.75}, {-79.2, 43.55}}).AsGeometry(),
+		geom.NewPointXY(-79.45, 43.75).AsGeometry(),
+	}).AsGeometry()
+	e := decodeFeature(t, store, g)
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 2000 {
+		p := s2.PointFromLatLng(s2.LatLngFromDegrees(43.5+0.3*rng.Float64(), -79.55+0.4*rng.Float64()))
+		limit := s1.ChordAngleFromAngle(s1.Angle(rng.Float64() * 3000 / earthRadiusMeters))
+		want := bruteDistance(e, p)
+
+		got, r, err := e.distance(p, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want <= limit {
+			if r != refineMatch || math.Abs(float64(got-want)) > 1e-15 {
+				t.Errorf("%v within %v: got %v (%v), want %v", s2.LatLngFromPoint(p), limit, got, r, want)
+			}
+		} else if r == refineMatch {
+			t.Errorf("%v within %v: got a match at %v, want %v", s2.LatLngFromPoint(p), limit, got, want)
+		}
+	}
+}
+
+// TestRefineLargePolygon validates that refining against a large polygon only measures the edges near the query
+func TestRefineLargePolygon(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	const vertices = 20000
+	e := decodeFeature(t, store, geom.NewPolygon([]geom.LineString{
+		circleRing(-79.38, 43.65, 0.5, vertices, false),
+	}).AsGeometry())
+
+	limit := s1.ChordAngleFromAngle(s1.Angle(1000 / earthRadiusMeters))
+	for _, ll := range []s2.LatLng{
+		s2.LatLngFromDegrees(43.65, -78.875), // Outside near the boundary
+		s2.LatLngFromDegrees(43.65, -78.885), // Inside near the boundary
+		s2.LatLngFromDegrees(44.154, -79.38), // Outside near the top
+	} {
+		p := s2.PointFromLatLng(ll)
+		want := bruteDistance(e, p)
+		got, r, err := e.distance(p, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != refineMatch || math.Abs(float64(got-want)) > 1e-15 {
+			t.Errorf("%v: got %v (%v), want %v", ll, got, r, want)
+		}
+		if want == 0 {
+			continue
+		}
+		_, measured, err := e.closestEdge(p, e.nearCells(p, limit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if measured == 0 || measured > vertices/100 {
+			t.Errorf("%v: measured %d edges out of %d", ll, measured, vertices)
+		}
+	}
+}
+
+// BenchmarkRefineLargePolygon reports the refinement latency by polygon size, on the default options
+func BenchmarkRefineLargePolygon(b *testing.B) {
+	store := newTestStore(b, DefaultOptions())
+	p := s2.PointFromLatLng(s2.LatLngFromDegrees(43.65, -78.875))
+	limit := s1.ChordAngleFromAngle(s1.Angle(1000 / earthRadiusMeters))
+	for _, vertices := range []int{1000, 10000, 100000} {
+		e := decodeFeature(b, store, geom.NewPolygon([]geom.LineString{
+			circleRing(-79.38, 43.65, 0.5, vertices, false),
+		}).AsGeometry())
+		b.Run(fmt.Sprintf("vertices=%d", vertices), func(b *testing.B) {
+			for range b.N {
+				if _, _, err := e.distance(p, limit); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// writeGrid writes n circles of the given number of vertices around lng/lat, spaced by 0.01 degree
+func writeGrid(t testing.TB, store *GeoStore, n, vertices int) {
+	t.Helper()
+	var entries []IndexEntry
+	for i := range n {
+		lng, lat := -79.5+0.01*float64(i%20), 43.5+0.01*float64(i/20)
+		feature := geom.GeoJSONFeature{
+			Geometry:   geom.NewPolygon([]geom.LineString{circleRing(lng, lat, 0.004, vertices, false)}).AsGeometry(),
+			Properties: map[string]any{"n": float64(i)},
+		}
+		entry, err := store.PrepareIndexEntry(fmt.Sprintf("circle-%d", i), feature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := store.WriteBatch(entries); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestQueryWorkers validates parallel refinement returns the same results as serial refinement
+func TestQueryWorkers(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	writeGrid(t, store, 200, 64)
+
+	serial, err := store.FindClosest(43.55, -79.4, 5000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serial) < 50 {
+		t.Fatalf("expected many results, got %d", len(serial))
+	}
+
+	store.opts.QueryWorkers = 4
+	parallel, err := store.FindClosest(43.55, -79.4, 5000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parallel) != len(serial) {
+		t.Fatalf("expected %d results, got %d", len(serial), len(parallel))
+	}
+	for i := range serial {
+		if serial[i].ID != parallel[i].ID || serial[i].Distance != parallel[i].Distance ||
+			serial[i].Properties["n"] != parallel[i].Properties["n"] {
+			t.Errorf("result %d differs: %+v != %+v", i, serial[i], parallel[i])
+		}
+	}
+
+	typed, err := FindClosestAs[struct{ N int }](store, 43.55, -79.4, 5000, QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range typed {
+		if r.ID != serial[i].ID || float64(r.Properties.N) != serial[i].Properties["n"] {
+			t.Errorf("typed result %d differs: %+v", i, r)
+		}
+	}
+}
+
+// TestQueryContext validates a query stops once its context is done
+func TestQueryContext(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	writeGrid(t, store, 40, 16)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, workers := range []int{1, 4} {
+		store.opts.QueryWorkers = workers
+		results, err := store.FindClosestWithOptions(43.55, -79.4, 50000, QueryOptions{Context: ctx})
+		if !errors.Is(err, context.Canceled) || results != nil {
+			t.Errorf("workers=%d: expected context.Canceled, got %v with %d results", workers, err, len(results))
+		}
+		results, err = store.FindClosestWithOptions(43.55, -79.4, 50000, QueryOptions{Context: context.Background()})
+		if err != nil || len(results) != 40 {
+			t.Errorf("workers=%d: expected 40 results, got %d, %v", workers, len(results), err)
+		}
+	}
+}
+
+// TestEachClosest validates results are emitted as refined, the same as FindClosest, and fn stops the query
+func TestEachClosest(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	writeGrid(t, store, 100, 16)
+
+	want, err := store.FindClosest(43.55, -79.4, 5000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errStop := errors.New("stop")
+	for _, workers := range []int{1, 4} {
+		store.opts.QueryWorkers = workers
+		var got []StoredItem
+		err := store.EachClosest(43.55, -79.4, 5000, QueryOptions{}, func(item StoredItem) error {
+			got = append(got, item)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.SortFunc(got, func(a, b StoredItem) int {
+			return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.ID, b.ID))
+		})
+		if !slices.EqualFunc(got, want, func(a, b StoredItem) bool { return a.ID == b.ID && a.Distance == b.Distance }) {
+			t.Errorf("workers=%d: got %d results, want %d", workers, len(got), len(want))
+		}
+
+		calls := 0
+		err = store.EachClosest(43.55, -79.4, 5000, QueryOptions{}, func(StoredItem) error {
+			calls++
+			return errStop
+		})
+		if !errors.Is(err, errStop) || calls != 1 {
+			t.Errorf("workers=%d: expected the query to stop after the first result, got %d calls, %v", workers, calls, err)
+		}
+	}
+}
+
+// BenchmarkQueryWorkers reports the latency of a wide query over many large candidates
+func BenchmarkQueryWorkers(b *testing.B) {
+	store := newTestStore(b, DefaultOptions())
+	writeGrid(b, store, 200, 2000)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			store.opts.QueryWorkers = workers
+			for range b.N {
+				if _, err := store.FindClosest(43.55, -79.4, 5000, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestFindContainingAndInRect validates the point and rectangle queries against the grid, with every layout
+func TestFindContainingAndInRect(t *testing.T) {
+	ids := func(results []StoredItem) []string {
+		out := make([]string, len(results))
+		for i, r := range results {
+			out[i] = r.ID
+		}
+		return out
+	}
+
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+		opts := DefaultOptions()
+		opts.IndexLayout = l
+		store := newTestStore(t, opts)
+		writeGrid(t, store, 100, 16)
+
+		contains := []struct {
+			lat, lng float64
+			want     []string
+		}{
+			{43.5, -79.5, []string{"circle-0"}},
+			{43.511, -79.469, []string{"circle-23"}},
+			{43.505, -79.495, []string{}}, // Between circles
+			{45, -75, []string{}},
+		}
+		for _, c := range contains {
+			results, err := store.FindContaining(c.lat, c.lng, QueryOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(results); !slices.Equal(got, c.want) {
+				t.Errorf("%s: containing %v,%v got %v, want %v", l, c.lat, c.lng, got, c.want)
+			}
+		}
+
+		rects := []struct {
+			minLat, minLng, maxLat, maxLng float64
+			want                           []string
+		}{
+			{43.495, -79.505, 43.515, -79.485, []string{"circle-0", "circle-1", "circle-20", "circle-21"}},
+			{43.499, -79.501, 43.501, -79.499, []string{"circle-0"}}, // Inside a polygon
+			// A thin rectangle along the first column, only crossing the boundary of circle-0
+			{43.5035, -79.5005, 43.6, -79.4995, []string{"circle-0", "circle-20", "circle-40", "circle-60", "circle-80"}},
+			{43.505, -79.496, 43.506, -79.494, []string{}}, // Between circles
+			{43.5, 179, 43.6, -179, []string{}},            // Across the antimeridian
+		}
+		for _, r := range rects {
+			results, err := store.FindInRect(r.minLat, r.minLng, r.maxLat, r.maxLng, QueryOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(results); !slices.Equal(got, r.want) {
+				t.Errorf("%s: rect %v got %v, want %v", l, r, got, r.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package geostore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/geo/r1"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Reader is implemented by GeoStore, each call reading its own snapshot, and by
+// Snapshot, all calls reading the same one.
+type Reader interface {
+	Has(id string) (bool, error)
+	Get(id string) (StoredItem, error)
+	FindClosest(lat, lng float64, radiusMeters float64, withGeometry bool) ([]StoredItem, error)
+	FindClosestWithOptions(lat, lng float64, radiusMeters float64, opts QueryOptions) ([]StoredItem, error)
+	EachClosest(lat, lng float64, radiusMeters float64, opts QueryOptions, fn func(StoredItem) error) error
+	FindContaining(lat, lng float64, opts QueryOptions) ([]StoredItem, error)
+	FindInRect(minLat, minLng, maxLat, maxLng float64, opts QueryOptions) ([]StoredItem, error)
+
+	view(fn func(*Snapshot) error) error
+}
+
+// Snapshot is a consistent read-only view of the store, writes committed after it
+// was opened are not visible. It is only valid inside the GeoStore.View callback.
+// Like the bbolt transaction it reads, it is not safe for concurrent use: call it
+// from the goroutine running the callback, or open a snapshot per goroutine.
+type Snapshot struct {
+	gs *GeoStore
+	tx *bolt.Tx
+}
+
+// View runs fn with a read-only snapshot of the store, to run several queries
+// against the same data. Keep snapshots short: they hold pages writers can't
+// reuse, and a write growing the file waits for them to close, so fn must not
+// wait for a write.
+func (gs *GeoStore) View(fn func(*Snapshot) error) error {
+	return gs.db.View(func(tx *bolt.Tx) error {
+		return fn(&Snapshot{gs: gs, tx: tx})
+	})
+}
+
+func (gs *GeoStore) view(fn func(*Snapshot) error) error { return gs.View(fn) }
+
+func (s *Snapshot) view(fn func(*Snapshot) error) error { return fn(s) }
+
+// Has reports whether an object is stored under id.
+func (s *Snapshot) Has(id string) (bool, error) {
+	return s.tx.Bucket([]byte(bucketObjects)).Get([]byte(id)) != nil, nil
+}
+
+// Get returns the object stored under id, with its geometry, or ErrNotFound.
+func (s *Snapshot) Get(id string) (StoredItem, error) {
+	data := s.tx.Bucket([]byte(bucketObjects)).Get([]byte(id))
+	if data == nil {
+		return StoredItem{}, ErrNotFound
+	}
+	entry, err := s.gs.decodeEntry(id, data)
+	if err != nil {
+		return StoredItem{}, err
+	}
+	geo, err := entry.geometry(entry.shapes())
+	if err != nil {
+		return StoredItem{}, err
+	}
+	props, err := unmarshalProperties(entry.props, entry.header.properties)
+	if err != nil {
+		return StoredItem{}, err
+	}
+	return StoredItem{ID: id, Geometry: geo, Properties: props}, nil
+}
+
+// FindClosest returns the objects within radiusMeters of lat/lng, closest first.
+func (s *Snapshot) FindClosest(lat, lng float64, radiusMeters float64, withGeometry bool) ([]StoredItem, error) {
+	return s.FindClosestWithOptions(lat, lng, radiusMeters, QueryOptions{WithGeometry: withGeometry})
+}
+
+// FindClosestWithOptions is FindClosest with control over the geometry and properties returned.
+func (s *Snapshot) FindClosestWithOptions(lat, lng float64, radiusMeters float64, opts QueryOptions) ([]StoredItem, error) {
+	region, refine := closestQuery(lat, lng, radiusMeters)
+	return s.query(QueryClosest, region, refine, opts, "lat", lat, "lng", lng, "radius", radiusMeters)
+}
+
+// EachClosest calls fn with the objects within radiusMeters of lat/lng as they are
+// refined, in no particular order, instead of gathering and sorting them. Calls are
+// serialized, an error from fn stops the query and is returned. fn runs while the
+// query holds the snapshot, a slow fn keeps it open.
+func (s *Snapshot) EachClosest(lat, lng float64, radiusMeters float64, opts QueryOptions, fn func(StoredItem) error) error {
+	region, refine := closestQuery(lat, lng, radiusMeters)
+	return s.each(QueryClosest, region, refine, opts, fn, "lat", lat, "lng", lng, "radius", radiusMeters)
+}
+
+// closestQuery returns the region and the refinement of the objects within
+// radiusMeters of lat/lng.
+func closestQuery(lat, lng float64, radiusMeters float64) (s2.Region, refineFunc) {
+	center := s2.PointFromLatLng(s2.LatLngFromDegrees(lat, lng))
+	angleRadius := s1.Angle(radiusMeters / earthRadiusMeters)
+	limit := s1.ChordAngleFromAngle(angleRadius)
+	refine := func(e *decodedEntry) (s1.ChordAngle, refinement, error) {
+		return e.distance(center, limit)
+	}
+	return s2.CapFromCenterAngle(center, angleRadius), refine
+}
+
+// FindContaining returns the objects covering lat/lng: the polygons containing
+// it, and the lines and points passing exactly through it.
+func (s *Snapshot) FindContaining(lat, lng float64, opts QueryOptions) ([]StoredItem, error) {
+	region, refine := closestQuery(lat, lng, 0)
+	return s.query(QueryContaining, region, refine, opts, "lat", lat, "lng", lng)
+}
+
+// FindInRect returns the objects intersecting the rectangle between the south-west
+// corner minLat/minLng and the north-east corner maxLat/maxLng, ordered by ID.
+// The rectangle may cross the antimeridian, minLng being greater than maxLng.
+// Results have no distance.
+func (s *Snapshot) FindInRect(minLat, minLng, maxLat, maxLng float64, opts QueryOptions) ([]StoredItem, error) {
+	lo, hi := s2.LatLngFromDegrees(minLat, minLng), s2.LatLngFromDegrees(maxLat, maxLng)
+	rect := s2.Rect{
+		Lat: r1.Interval{Lo: lo.Lat.Radians(), Hi: hi.Lat.Radians()},
+		Lng: s1.IntervalFromEndpoints(lo.Lng.Radians(), hi.Lng.Radians()),
+	}
+	refine := func(e *decodedEntry) (s1.ChordAngle, refinement, error) {
+		r, err := e.intersectsRect(rect)
+		return 0, r, err
+	}
+	return s.query(QueryRect, rect, refine, opts,
+		"min_lat", minLat, "min_lng", minLng, "max_lat", maxLat, "max_lng", maxLng)
+}
+
+// query gathers the candidates of region from the index and refines them with
+// refine, results are ordered by distance then ID. attrs describe the query in logs.
+func (s *Snapshot) query(kind QueryKind, region s2.Region, refine refineFunc, opts QueryOptions, attrs ...any) ([]StoredItem, error) {
+	var results []StoredItem
+	err := s.each(kind, region, refine, opts, func(item StoredItem) error {
+		results = append(results, item)
+		return nil
+	}, attrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ties are ordered by ID, results don't depend on the refinement order
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+// each gathers the candidates of region from the index, refines them with refine
+// and calls emit with the results as they match, see GeoStore.refineCandidates.
+// Candidates are gathered and refined in the snapshot, every candidate has its blob.
+// The query is observed as kind.
+func (s *Snapshot) each(kind QueryKind, region s2.Region, refine refineFunc, opts QueryOptions, emit func(StoredItem) error, attrs ...any) error {
+	start := time.Now()
+	opts.trace = nil
+	if opts.Explain != nil {
+		*opts.Explain = Explain{}
+		opts.trace = &queryTrace{explain: opts.Explain}
+	}
+
+	l, layout, err := s.gs.txLayout(s.tx)
+	if err != nil {
+		return err
+	}
+	if opts.trace != nil {
+		opts.Explain.Layout = l
+	}
+
+	// Candidates are split by the covering they matched:
+	// 1. Interior candidates: matched via interior cover keys (the region overlaps the polygon interior)
+	// 2. Exterior candidates: matched only via exterior cover keys (the region overlaps the boundary cells)
+	// Both are refined against the per-object index.
+	interiorCandidates := make(map[string]struct{})
+	exteriorCandidates := make(map[string]struct{})
+	err = layout.candidates(s.tx, region, func(id []byte, interior bool) {
+		// Looked up first, converting the ID only for new candidates
+		if _, isInterior := interiorCandidates[string(id)]; isInterior {
+			return
+		}
+		if interior {
+			interiorCandidates[string(id)] = struct{}{}
+			delete(exteriorCandidates, string(id))
+		} else if _, isExterior := exteriorCandidates[string(id)]; !isExterior {
+			exteriorCandidates[string(id)] = struct{}{}
+		}
+	}, opts.trace)
+	if err != nil {
+		return err
+	}
+	if opts.trace != nil {
+		opts.Explain.InteriorCandidates = len(interiorCandidates)
+		opts.Explain.ExteriorCandidates = len(exteriorCandidates)
+		opts.Explain.IndexTime = time.Since(start)
+	}
+
+	// Process interior candidates first
+	candidates := make([]string, 0, len(interiorCandidates)+len(exteriorCandidates))
+	for id := range interiorCandidates {
+		candidates = append(candidates, id)
+	}
+	for id := range exteriorCandidates {
+		candidates = append(candidates, id)
+	}
+
+	matched := 0
+	err = s.gs.refineCandidates(s.tx.Bucket([]byte(bucketObjects)), candidates, refine, opts, func(item StoredItem) error {
+		matched++
+		return emit(item)
+	})
+	if err != nil {
+		return err
+	}
+
+	d := time.Since(start)
+	s.gs.opts.Metrics.ObserveQuery(kind, d, len(candidates), matched)
+	s.gs.opts.Logger.Debug("query", append(attrs, "kind", kind.String(),
+		"candidates", len(candidates), "results", matched, "duration", d)...)
+	if opts.trace != nil {
+		opts.trace.finish(start, matched)
+	}
+	return nil
+}
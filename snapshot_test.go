@@ -0,0 +1,77 @@
+package geostore
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSnapshot validates queries in a snapshot don't see writes committed after it was opened
+func TestSnapshot(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	write := func(id string, lng float64) {
+		feature := polygonFeature([][]float64{
+			{lng, 43.6}, {lng + 0.1, 43.6}, {lng + 0.1, 43.7}, {lng, 43.7}, {lng, 43.6},
+		})
+		feature.Properties = map[string]any{"name": id}
+		entry, err := store.PrepareIndexEntry(id, feature)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Error(err)
+		}
+	}
+	write("a", -79.4)
+
+	done := make(chan struct{})
+	err := store.View(func(snap *Snapshot) error {
+		before, err := snap.FindClosest(43.65, -79.35, 20000, false)
+		if err != nil {
+			return err
+		}
+
+		// Written while the snapshot is open. The commit may wait for the snapshot
+		// to close when the file has to grow, it is not awaited.
+		go func() {
+			defer close(done)
+			write("b", -79.3)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		after, err := snap.FindClosest(43.65, -79.35, 20000, false)
+		if err != nil {
+			return err
+		}
+		if len(before) != 1 || len(after) != 1 {
+			t.Errorf("expected the snapshot to only see a, got %+v then %+v", before, after)
+		}
+		if ok, _ := snap.Has("b"); ok {
+			t.Error("b is visible in the snapshot")
+		}
+		if _, err := snap.Get("a"); err != nil {
+			t.Error(err)
+		}
+
+		typed, err := FindClosestAs[struct{ Name string }](snap, 43.65, -79.35, 20000, QueryOptions{})
+		if err != nil {
+			return err
+		}
+		if len(typed) != 1 || typed[0].Properties.Name != "a" {
+			t.Errorf("unexpected typed results %+v", typed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+	results, err := store.FindClosest(43.65, -79.35, 20000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("expected both objects once the snapshot is closed, got %+v", results)
+	}
+}
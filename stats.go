@@ -0,0 +1,163 @@
+package geostore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/golang/geo/s2"
+	geom "github.com/peterstace/simplefeatures/geom"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Stats describes the content of a store, see GeoStore.Stats.
+type Stats struct {
+	Objects       int
+	GeometryTypes map[string]int // Objects by ingested geometry type, "Unknown" for legacy blobs
+	BlobSizes     SizeStats      // Stored size of the objects, compressed
+
+	IndexLayout IndexLayout
+	IndexKeys   int
+	IndexLevels []LevelStats // Index keys by cell level, lowest level first
+
+	Buckets   map[string]bolt.BucketStats // Page statistics by bucket name
+	FileSize  int64                       // Size of the database file
+	FreePages int                         // Pages freed by past writes, ready to be reused
+}
+
+// SizeStats summarizes a distribution of sizes in bytes.
+type SizeStats struct {
+	Total         int64
+	Min, Max      int
+	Mean          float64
+	P50, P90, P99 int
+}
+
+// LevelStats counts the index keys of a cell level.
+type LevelStats struct {
+	Level     int
+	Interior  int // Keys of interior covering cells
+	Exterior  int // Keys of exterior covering cells
+	Ancestors int // Keys of ancestors of covering cells, IndexLayoutTerms only
+}
+
+// Stats scans the store and returns its statistics, in a single read transaction.
+// Every object is decoded, it takes about as long as reading the whole file.
+func (gs *GeoStore) Stats() (Stats, error) {
+	stats := Stats{
+		GeometryTypes: make(map[string]int),
+		Buckets:       make(map[string]bolt.BucketStats),
+		FreePages:     gs.db.Stats().FreePageN,
+	}
+
+	err := gs.db.View(func(tx *bolt.Tx) error {
+		stats.FileSize = tx.Size()
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			stats.Buckets[string(name)] = b.Stats()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var sizes []int
+		err = tx.Bucket([]byte(bucketObjects)).ForEach(func(k, v []byte) error {
+			entry, err := decodeFullEntry(v)
+			if err != nil {
+				return fmt.Errorf("entry %s: %w", k, err)
+			}
+			stats.Objects++
+			stats.GeometryTypes[wkbTypeName(entry.info.typ)]++
+			sizes = append(sizes, len(v))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		stats.BlobSizes = sizeStats(sizes)
+
+		stats.IndexLayout, _, err = gs.txLayout(tx)
+		if err != nil {
+			return err
+		}
+		return indexLevels(tx.Bucket([]byte(bucketIndex)), stats.IndexLayout, &stats)
+	})
+	return stats, err
+}
+
+// indexLevels counts the index keys by level.
+func indexLevels(bIdx *bolt.Bucket, l IndexLayout, stats *Stats) error {
+	var levels [s2.MaxLevel + 1]LevelStats
+	err := bIdx.ForEach(func(k, _ []byte) error {
+		stats.IndexKeys++
+
+		var cell s2.CellID
+		var interior, ancestor bool
+		if l == IndexLayoutTerms {
+			prefix, rest, _ := bytes.Cut(k, []byte(":"))
+			token, _, _ := bytes.Cut(rest, []byte{0})
+			interior = string(prefix) == "int"
+			ancestor = !bytes.HasPrefix(token, []byte("$"))
+			cell = s2.CellIDFromToken(strings.TrimPrefix(string(token), "$"))
+		} else if len(k) >= 9 {
+			cell = s2.CellID(binary.BigEndian.Uint64(k))
+			interior = k[8] == kindInterior
+		}
+		if !cell.IsValid() {
+			return fmt.Errorf("invalid index key %q", k)
+		}
+
+		level := &levels[cell.Level()]
+		switch {
+		case ancestor:
+			level.Ancestors++
+		case interior:
+			level.Interior++
+		default:
+			level.Exterior++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, level := range levels {
+		if level.Interior+level.Exterior+level.Ancestors > 0 {
+			level.Level = i
+			stats.IndexLevels = append(stats.IndexLevels, level)
+		}
+	}
+	return nil
+}
+
+// sizeStats summarizes sizes, sorting them.
+func sizeStats(sizes []int) SizeStats {
+	if len(sizes) == 0 {
+		return SizeStats{}
+	}
+	slices.Sort(sizes)
+	s := SizeStats{Min: sizes[0], Max: sizes[len(sizes)-1]}
+	for _, size := range sizes {
+		s.Total += int64(size)
+	}
+	s.Mean = float64(s.Total) / float64(len(sizes))
+	percentile := func(p int) int { return sizes[(len(sizes)-1)*p/100] }
+	s.P50, s.P90, s.P99 = percentile(50), percentile(90), percentile(99)
+	return s
+}
+
+// wkbTypeName returns the name of the geometry type of a WKB type code.
+func wkbTypeName(code byte) string {
+	for _, t := range []geom.GeometryType{
+		geom.TypePoint, geom.TypeLineString, geom.TypePolygon, geom.TypeMultiPoint,
+		geom.TypeMultiLineString, geom.TypeMultiPolygon, geom.TypeGeometryCollection,
+	} {
+		if wkbTypeCode(t) == code {
+			return t.String()
+		}
+	}
+	return "Unknown"
+}
@@ -0,0 +1,59 @@
+package geostore
+
+import (
+	"testing"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// TestStats validates the statistics of a store in each index layout
+func TestStats(t *testing.T) {
+	line := geom.GeoJSONFeature{Geometry: makeLineString([][]float64{{-79.6, 43.4}, {-79.2, 43.8}}).AsGeometry()}
+
+	for _, l := range []IndexLayout{IndexLayoutTerms, IndexLayoutCells, IndexLayoutCompact} {
+		opts := DefaultOptions()
+		opts.IndexLayout = l
+		store := newTestStore(t, opts)
+		writeGrid(t, store, 20, 8)
+		entry, err := store.PrepareIndexEntry("line", line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := store.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Objects != 21 || stats.GeometryTypes["Polygon"] != 20 || stats.GeometryTypes["LineString"] != 1 {
+			t.Errorf("%s: unexpected objects %d %v", l, stats.Objects, stats.GeometryTypes)
+		}
+		if s := stats.BlobSizes; s.Min <= 0 || s.Min > s.P50 || s.P50 > s.P99 || s.P99 > s.Max || s.Total < int64(21*s.Min) {
+			t.Errorf("%s: inconsistent blob sizes %+v", l, s)
+		}
+		if stats.IndexLayout != l || stats.IndexKeys != countKeys(t, store) {
+			t.Errorf("%s: got layout %s with %d keys, want %d", l, stats.IndexLayout, stats.IndexKeys, countKeys(t, store))
+		}
+
+		keys, interior, ancestors := 0, 0, 0
+		for _, level := range stats.IndexLevels {
+			if level.Level < 4 || level.Level > 16 {
+				t.Errorf("%s: unexpected level %d", l, level.Level)
+			}
+			keys += level.Interior + level.Exterior + level.Ancestors
+			interior += level.Interior
+			ancestors += level.Ancestors
+		}
+		if keys != stats.IndexKeys || interior == 0 {
+			t.Errorf("%s: levels count %d keys, %d interior, want %d keys", l, keys, interior, stats.IndexKeys)
+		}
+		if (ancestors > 0) != (l == IndexLayoutTerms) {
+			t.Errorf("%s: unexpected %d ancestor keys", l, ancestors)
+		}
+		if stats.Buckets[bucketObjects].KeyN != 21 || stats.FileSize == 0 {
+			t.Errorf("%s: unexpected bucket stats %+v", l, stats.Buckets[bucketObjects])
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package geostore
+
+import (
+	"fmt"
+	"sync"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+// TypedItem is a stored item with its properties decoded into T.
+type TypedItem[T any] struct {
+	ID         string
+	Geometry   geom.Geometry
+	Properties T
+	Distance   float64
+}
+
+// PropertiesError is returned by FindClosestAs and GetAs when the stored properties
+// of an object can't be decoded into T, e.g. when T doesn't match them.
+type PropertiesError struct {
+	ID  string
+	Err error
+}
+
+func (e *PropertiesError) Error() string {
+	return fmt.Sprintf("decoding the properties of %s: %v", e.ID, e.Err)
+}
+
+func (e *PropertiesError) Unwrap() error { return e.Err }
+
+// FindClosestAs is FindClosestWithOptions decoding the properties of each result
+// directly into T, without an intermediate map. T is usually a struct, decoded
+// following its json tags, or its cbor tags for PropertiesCBOR entries.
+// The Properties and RawProperties options are ignored. r is a GeoStore or a Snapshot.
+// A result whose properties don't decode into T fails the query with a PropertiesError.
+func FindClosestAs[T any](r Reader, lat, lng float64, radiusMeters float64, opts QueryOptions) ([]TypedItem[T], error) {
+	// Candidates may be refined concurrently
+	var mu sync.Mutex
+	props := make(map[string]T)
+	opts.Properties, opts.RawProperties = nil, false
+	opts.decodeInto = func(id string, data []byte, e PropertyEncoding) error {
+		var v T
+		if err := unmarshalPropertiesInto(data, e, &v); err != nil {
+			return &PropertiesError{ID: id, Err: err}
+		}
+		mu.Lock()
+		props[id] = v
+		mu.Unlock()
+		return nil
+	}
+
+	items, err := r.FindClosestWithOptions(lat, lng, radiusMeters, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]TypedItem[T], len(items))
+	for i, item := range items {
+		results[i] = TypedItem[T]{ID: item.ID, Geometry: item.Geometry, Properties: props[item.ID], Distance: item.Distance}
+	}
+	return results, nil
+}
+
+// GetAs is Get decoding the properties into T, like FindClosestAs.
+func GetAs[T any](r Reader, id string) (TypedItem[T], error) {
+	item := TypedItem[T]{ID: id}
+	err := r.view(func(snap *Snapshot) error {
+		data := snap.tx.Bucket([]byte(bucketObjects)).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		entry, err := snap.gs.decodeEntry(id, data)
+		if err != nil {
+			return err
+		}
+		if item.Geometry, err = entry.geometry(entry.shapes()); err != nil {
+			return err
+		}
+		if err := unmarshalPropertiesInto(entry.props, entry.header.properties, &item.Properties); err != nil {
+			return &PropertiesError{ID: id, Err: err}
+		}
+		return nil
+	})
+	return item, err
+}
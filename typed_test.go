@@ -0,0 +1,89 @@
+package geostore
+
+import (
+	"errors"
+	"testing"
+)
+
+type district struct {
+	Name   string   `json:"name"`
+	Pop    int      `json:"pop"`
+	Area   float64  `json:"area"`
+	Open   bool     `json:"open"`
+	Tags   []string `json:"tags"`
+	Ignore string   `json:"-"`
+}
+
+// TestFindClosestAs validates properties are decoded into structs for every property encoding
+func TestFindClosestAs(t *testing.T) {
+	store := newTestStore(t, DefaultOptions())
+	raw := []byte(`{"type": "Feature",
+		"geometry": {"type": "Polygon", "coordinates": [[[-79.4, 43.6], [-79.3, 43.6], [-79.3, 43.7], [-79.4, 43.7], [-79.4, 43.6]]]},
+		"properties": {"name": "Downtown", "pop": 1234, "area": 12.5, "open": true, "tags": ["a", "b"], "extra": {"x": 1}}}`)
+	want := district{Name: "Downtown", Pop: 1234, Area: 12.5, Open: true, Tags: []string{"a", "b"}}
+
+	for _, e := range []PropertyEncoding{PropertiesJSON, PropertiesCBOR} {
+		store.opts.PropertyEncoding = e
+		if err := store.Put(e.String(), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := FindClosestAs[district](store, 43.65, -79.35, 10, QueryOptions{WithGeometry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	for _, r := range results {
+		p := r.Properties
+		if p.Name != want.Name || p.Pop != want.Pop || p.Area != want.Area || p.Open != want.Open ||
+			len(p.Tags) != 2 || p.Tags[1] != "b" {
+			t.Errorf("%s: got %+v, want %+v", r.ID, p, want)
+		}
+		if r.Geometry.IsEmpty() || r.Distance != 0 {
+			t.Errorf("%s: unexpected result %+v", r.ID, r)
+		}
+	}
+
+	item, err := GetAs[district](store, "cbor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Properties.Name != want.Name || item.Properties.Pop != want.Pop {
+		t.Errorf("GetAs: got %+v", item.Properties)
+	}
+	if _, err := GetAs[district](store, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// TestFindClosestAsMismatch validates properties not matching T fail the typed queries
+// instead of being reported as corrupted entries
+func TestFindClosestAsMismatch(t *testing.T) {
+	metrics := &recordingMetrics{}
+	opts := DefaultOptions()
+	opts.Metrics = metrics
+	store := newTestStore(t, opts)
+	writeGrid(t, store, 40, 8)
+
+	// The properties are {"n": float64}
+	type mismatch struct {
+		N string `json:"n"`
+	}
+	for _, workers := range []int{1, 4} {
+		store.opts.QueryWorkers = workers
+		results, err := FindClosestAs[mismatch](store, 43.5, -79.5, 5000, QueryOptions{})
+		var perr *PropertiesError
+		if !errors.As(err, &perr) || perr.ID == "" || results != nil {
+			t.Errorf("workers=%d: expected a PropertiesError, got %v with %d results", workers, err, len(results))
+		}
+	}
+	if _, err := GetAs[mismatch](store, "circle-0"); !errors.As(err, new(*PropertiesError)) {
+		t.Errorf("GetAs: expected a PropertiesError, got %v", err)
+	}
+	if metrics.decodeErrors != 0 {
+		t.Errorf("expected no decode error, got %d", metrics.decodeErrors)
+	}
+}
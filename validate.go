@@ -0,0 +1,212 @@
+package geostore
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/golang/geo/s2"
+)
+
+// ValidationPolicy controls how PrepareIndexEntry handles invalid geometries.
+type ValidationPolicy int
+
+const (
+	// ValidationAccept indexes geometries as they are, invalid polygons may answer
+	// containment queries wrongly.
+	ValidationAccept ValidationPolicy = iota
+
+	// ValidationReject rejects any geometry that is not valid as is.
+	ValidationReject
+
+	// ValidationFix repairs what can be repaired (duplicate vertices, ring
+	// orientation, degenerate holes) and rejects the rest (self-intersections,
+	// degenerate shells).
+	ValidationFix
+)
+
+func (p ValidationPolicy) String() string {
+	switch p {
+	case ValidationAccept:
+		return "accept"
+	case ValidationReject:
+		return "reject"
+	case ValidationFix:
+		return "fix"
+	}
+	return fmt.Sprintf("ValidationPolicy(%d)", int(p))
+}
+
+// ParseValidationPolicy parses "accept", "reject" or "fix".
+func ParseValidationPolicy(s string) (ValidationPolicy, error) {
+	for _, p := range []ValidationPolicy{ValidationAccept, ValidationReject, ValidationFix} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown validation policy %q (want accept, reject or fix)", s)
+}
+
+// validator checks geometries while they are converted to S2 and records the repairs applied.
+type validator struct {
+	policy  ValidationPolicy
+	repairs []string
+}
+
+// invalid returns the error for a problem that can't be repaired.
+func (v *validator) invalid(where, format string, args ...any) error {
+	return fmt.Errorf("%w: %s: %s", ErrInvalidGeometry, where, fmt.Sprintf(format, args...))
+}
+
+// repair records a repair, or returns an error when repairs are not allowed.
+func (v *validator) repair(where, format string, args ...any) error {
+	if v.policy != ValidationFix {
+		return v.invalid(where, format, args...)
+	}
+	v.repairs = append(v.repairs, where+": "+fmt.Sprintf(format, args...))
+	return nil
+}
+
+// point checks the coordinates of a point are within the WGS84 range.
+func (v *validator) point(lng, lat float64, where string) error {
+	if v.policy == ValidationAccept {
+		return nil
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return v.invalid(where, "coordinates (%g, %g) out of range", lng, lat)
+	}
+	return nil
+}
+
+// vertices removes consecutive duplicate vertices. For rings, the closing
+// vertex repeating the first one is expected to be removed by the caller.
+func (v *validator) vertices(pts []s2.Point, ring bool, where string) ([]s2.Point, error) {
+	if v.policy == ValidationAccept || len(pts) < 2 {
+		return pts, nil
+	}
+	out := pts[:1:1]
+	for _, pt := range pts[1:] {
+		if pt != out[len(out)-1] {
+			out = append(out, pt)
+		}
+	}
+	if ring && len(out) > 1 && out[len(out)-1] == out[0] {
+		out = out[:len(out)-1]
+	}
+	if removed := len(pts) - len(out); removed > 0 {
+		if err := v.repair(where, "removed %d duplicate vertices", removed); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// polyline validates the vertices of a line.
+func (v *validator) polyline(pts []s2.Point, where string) ([]s2.Point, error) {
+	pts, err := v.vertices(pts, false, where)
+	if err != nil {
+		return nil, err
+	}
+	if v.policy != ValidationAccept && len(pts) < 2 {
+		return nil, v.invalid(where, "degenerate line with %d distinct vertices", len(pts))
+	}
+	return pts, nil
+}
+
+// loop builds a ring as a loop oriented for PolygonFromOrientedLoops, shells
+// counterclockwise and holes clockwise. It returns a nil loop for a degenerate
+// hole dropped by the fix policy.
+func (v *validator) loop(pts []s2.Point, hole bool, where string) (*s2.Loop, error) {
+	if v.policy == ValidationAccept {
+		return s2.LoopFromPoints(pts), nil
+	}
+
+	pts, err := v.vertices(pts, true, where)
+	if err != nil {
+		return nil, err
+	}
+	if len(pts) < 3 {
+		if !hole {
+			return nil, v.invalid(where, "degenerate ring with %d distinct vertices", len(pts))
+		}
+		if err := v.repair(where, "dropped degenerate hole with %d distinct vertices", len(pts)); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	loop := s2.LoopFromPoints(pts)
+	if err := loop.Validate(); err != nil {
+		return nil, v.invalid(where, "%v", err)
+	}
+	if selfIntersects(loop) {
+		return nil, v.invalid(where, "ring is self-intersecting")
+	}
+
+	// GeoJSON rings follow the right-hand rule in lng/lat, whatever their size on
+	// the sphere: a shell larger than a hemisphere is still counterclockwise.
+	// A ring around a pole has no orientation in lng/lat, it bounds the side
+	// smaller than a hemisphere, like the loops of S2.
+	reverse := loop.IsNormalized() == hole
+	if area, ok := ringArea(pts); ok {
+		reverse = (area < 0 && !hole) || (area > 0 && hole)
+	}
+	if reverse {
+		if err := v.repair(where, "reversed ring orientation"); err != nil {
+			return nil, err
+		}
+		loop.Invert()
+	}
+	return loop, nil
+}
+
+// ringArea returns the signed area of a ring in planar lng/lat, positive when it
+// is counterclockwise. Longitudes are unwrapped, a ring crossing the antimeridian
+// keeps its orientation. ok is false for a ring around a pole: once unwrapped, it
+// ends 360 degrees away from its start and doesn't close.
+func ringArea(pts []s2.Point) (area float64, ok bool) {
+	lngs := make([]float64, len(pts))
+	lats := make([]float64, len(pts))
+	for i, pt := range pts {
+		ll := s2.LatLngFromPoint(pt)
+		lngs[i], lats[i] = ll.Lng.Degrees(), ll.Lat.Degrees()
+		if i > 0 {
+			lngs[i] = lngs[i-1] + math.Remainder(lngs[i]-lngs[i-1], 360)
+		}
+	}
+	last := len(pts) - 1
+	if end := lngs[last] + math.Remainder(lngs[0]-lngs[last], 360); math.Abs(end-lngs[0]) > 180 {
+		return 0, false
+	}
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		area += lngs[i]*lats[j] - lngs[j]*lats[i]
+	}
+	return area / 2, true
+}
+
+// polygon validates the assembled polygon.
+func (v *validator) polygon(p *s2.Polygon, where string) error {
+	if v.policy == ValidationAccept {
+		return nil
+	}
+	if err := p.Validate(); err != nil {
+		return v.invalid(where, "%v", err)
+	}
+	return nil
+}
+
+// selfIntersects reports whether two non adjacent edges of the loop cross.
+func selfIntersects(loop *s2.Loop) bool {
+	index := s2.NewShapeIndex()
+	index.Add(loop)
+	query := s2.NewCrossingEdgeQuery(index)
+	for i := range loop.NumEdges() {
+		e := loop.Edge(i)
+		for _, j := range query.Crossings(e.V0, e.V1, loop, s2.CrossingTypeInterior) {
+			if j != i {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package geostore
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	geom "github.com/peterstace/simplefeatures/geom"
+)
+
+func newTestStore(t testing.TB, opts Options) *GeoStore {
+	t.Helper()
+	tmpFile, err := os.CreateTemp("", "geo_validate_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := tmpFile.Name()
+	tmpFile.Close()
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	store, err := NewGeoStoreWithOptions(dbPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// polygonFeature builds a feature without validation from rings of lng/lat pairs.
+func polygonFeature(rings ...[][]float64) geom.GeoJSONFeature {
+	lss := make([]geom.LineString, len(rings))
+	for i, r := range rings {
+		lss[i] = makeLineString(r)
+	}
+	return geom.GeoJSONFeature{Geometry: geom.NewPolygon(lss).AsGeometry()}
+}
+
+// TestValidationOrientation validates that clockwise shells are fixed or rejected
+func TestValidationOrientation(t *testing.T) {
+	// Clockwise exterior ring, wrong winding for GeoJSON
+	cw := polygonFeature([][]float64{
+		{-79.40, 43.64}, {-79.40, 43.66}, {-79.37, 43.66}, {-79.37, 43.64}, {-79.40, 43.64},
+	})
+
+	for _, tc := range []struct {
+		policy      ValidationPolicy
+		wantErr     bool
+		wantRepairs bool
+		wantInside  bool
+	}{
+		{policy: ValidationAccept, wantInside: false},
+		{policy: ValidationReject, wantErr: true},
+		{policy: ValidationFix, wantRepairs: true, wantInside: true},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			store := newTestStore(t, Options{Validation: tc.policy})
+			entry, err := store.PrepareIndexEntry("box", cw)
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidGeometry) {
+					t.Fatalf("Expected ErrInvalidGeometry, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(entry.Repairs) > 0; got != tc.wantRepairs {
+				t.Errorf("Expected repairs=%v, got %v", tc.wantRepairs, entry.Repairs)
+			}
+			if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := store.FindClosest(43.65, -79.385, 10, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inside := len(results) == 1 && results[0].Distance == 0
+			if inside != tc.wantInside {
+				t.Errorf("Expected point inside=%v, got results %+v", tc.wantInside, results)
+			}
+		})
+	}
+}
+
+// TestValidationRepairs validates duplicate vertices removal and degenerate holes
+func TestValidationRepairs(t *testing.T) {
+	store := newTestStore(t, Options{Validation: ValidationFix})
+
+	feature := polygonFeature(
+		[][]float64{{-80.0, 43.0}, {-78.0, 43.0}, {-78.0, 43.0}, {-78.0, 45.0}, {-80.0, 45.0}, {-80.0, 43.0}},
+		[][]float64{{-79.5, 43.5}, {-79.4, 43.5}, {-79.5, 43.5}},
+	)
+	entry, err := store.PrepareIndexEntry("dups", feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Repairs) != 2 {
+		t.Errorf("Expected 2 repairs (duplicate vertex, degenerate hole), got %v", entry.Repairs)
+	}
+
+	reject := newTestStore(t, Options{Validation: ValidationReject})
+	if _, err := reject.PrepareIndexEntry("dups", feature); !errors.Is(err, ErrInvalidGeometry) {
+		t.Errorf("Expected ErrInvalidGeometry with reject policy, got %v", err)
+	}
+}
+
+// TestValidationUnfixable validates that self-intersecting rings are rejected even when fixing
+func TestValidationUnfixable(t *testing.T) {
+	store := newTestStore(t, Options{Validation: ValidationFix})
+
+	bowtie := polygonFeature([][]float64{
+		{-80.0, 43.0}, {-78.0, 45.0}, {-78.0, 43.0}, {-80.0, 45.0}, {-80.0, 43.0},
+	})
+	if _, err := store.PrepareIndexEntry("bowtie", bowtie); !errors.Is(err, ErrInvalidGeometry) {
+		t.Errorf("Expected ErrInvalidGeometry for a self-intersecting ring, got %v", err)
+	}
+
+	line := geom.GeoJSONFeature{Geometry: makeLineString([][]float64{{-79.0, 43.0}, {-79.0, 43.0}}).AsGeometry()}
+	if _, err := store.PrepareIndexEntry("line", line); !errors.Is(err, ErrInvalidGeometry) {
+		t.Errorf("Expected ErrInvalidGeometry for a degenerate line, got %v", err)
+	}
+}
+
+// TestValidationLargeShell validates that orientation follows the right-hand rule in
+// lng/lat, shells larger than a hemisphere and crossing the antimeridian are kept
+func TestValidationLargeShell(t *testing.T) {
+	store := newTestStore(t, Options{Validation: ValidationFix})
+
+	for _, tc := range []struct {
+		name      string
+		ring      [][]float64
+		inside    [2]float64 // lng/lat
+		outside   [2]float64
+		wantFixed bool
+	}{
+		{
+			name:    "larger than a hemisphere",
+			ring:    [][]float64{{-170, -80}, {0, -80}, {170, -80}, {170, 80}, {0, 80}, {-170, 80}, {-170, -80}},
+			inside:  [2]float64{0, 0},
+			outside: [2]float64{180, 0},
+		},
+		{
+			name:    "crossing the antimeridian",
+			ring:    [][]float64{{170, -10}, {-170, -10}, {-170, 10}, {170, 10}, {170, -10}},
+			inside:  [2]float64{180, 0},
+			outside: [2]float64{0, 0},
+		},
+		{
+			name:      "clockwise crossing the antimeridian",
+			ring:      [][]float64{{170, -10}, {170, 10}, {-170, 10}, {-170, -10}, {170, -10}},
+			inside:    [2]float64{180, 0},
+			outside:   [2]float64{0, 0},
+			wantFixed: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := store.PrepareIndexEntry("shell", polygonFeature(tc.ring))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fixed := len(entry.Repairs) > 0; fixed != tc.wantFixed {
+				t.Errorf("Expected fixed=%v, got repairs %v", tc.wantFixed, entry.Repairs)
+			}
+			if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []struct {
+				lngLat [2]float64
+				want   bool
+			}{{tc.inside, true}, {tc.outside, false}} {
+				results, err := store.FindContaining(p.lngLat[1], p.lngLat[0], QueryOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := len(results) == 1; got != p.want {
+					t.Errorf("Expected %v inside=%v, got %+v", p.lngLat, p.want, results)
+				}
+			}
+		})
+	}
+}
+
+// polarRing returns a ring along the parallel lat, eastward or westward around the pole.
+func polarRing(lat float64, east bool) [][]float64 {
+	var ring [][]float64
+	for lng := -180.0; lng < 180; lng += 30 {
+		if east {
+			ring = append(ring, []float64{lng, lat})
+		} else {
+			ring = append(ring, []float64{-lng, lat})
+		}
+	}
+	return append(ring, ring[0])
+}
+
+// TestValidationPolarRing validates the rings around a pole bound the side smaller
+// than a hemisphere, whatever their direction
+func TestValidationPolarRing(t *testing.T) {
+	store := newTestStore(t, Options{Validation: ValidationFix})
+
+	for _, tc := range []struct {
+		name      string
+		rings     [][][]float64
+		inside    [2]float64 // lng/lat
+		outside   [][2]float64
+		wantFixed bool
+	}{
+		{
+			name:    "eastward shell",
+			rings:   [][][]float64{polarRing(80, true)},
+			inside:  [2]float64{10, 85},
+			outside: [][2]float64{{10, 0}, {10, -85}},
+		},
+		{
+			name:      "westward shell",
+			rings:     [][][]float64{polarRing(80, false)},
+			inside:    [2]float64{10, 85},
+			outside:   [][2]float64{{10, 0}, {10, -85}},
+			wantFixed: true,
+		},
+		{
+			name:      "eastward hole",
+			rings:     [][][]float64{polarRing(70, true), polarRing(80, true)},
+			inside:    [2]float64{10, 75},
+			outside:   [][2]float64{{10, 85}, {10, 0}},
+			wantFixed: true,
+		},
+		{
+			name:    "westward hole",
+			rings:   [][][]float64{polarRing(70, true), polarRing(80, false)},
+			inside:  [2]float64{10, 75},
+			outside: [][2]float64{{10, 85}, {10, 0}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := store.PrepareIndexEntry("polar", polygonFeature(tc.rings...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fixed := len(entry.Repairs) > 0; fixed != tc.wantFixed {
+				t.Errorf("Expected fixed=%v, got repairs %v", tc.wantFixed, entry.Repairs)
+			}
+			if err := store.WriteBatch([]IndexEntry{entry}); err != nil {
+				t.Fatal(err)
+			}
+			for _, lngLat := range append([][2]float64{tc.inside}, tc.outside...) {
+				results, err := store.FindContaining(lngLat[1], lngLat[0], QueryOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := len(results) == 1, lngLat == tc.inside; got != want {
+					t.Errorf("Expected %v inside=%v, got %+v", lngLat, want, results)
+				}
+			}
+		})
+	}
+}